@@ -26,6 +26,11 @@ type Command interface {
 	Invoke(context.Context) error
 }
 
+// Reporter is a Command that writes its result as JSON once it is invoked
+type Reporter interface {
+	Report(io.Writer) error
+}
+
 type Query interface {
 	Invoke(context.Context, io.Writer) error
 }
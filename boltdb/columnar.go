@@ -287,15 +287,21 @@ func PrefixQuery(
 	return rows, nil
 }
 
-// LookupID retreives the bolt database id of a primary key.
+// LookupID retreives the bolt database id of a primary key. Only an index
+// entry of the exact primary key matches, not one of a longer key that starts
+// with it.
 func LookupID(b *bolt.Bucket, pk PK) ([]byte, error) {
 	index, prefix := pk.PK()
-	ids, err := PrefixQuery(b, index, prefix, 1, nil)
-	if err != nil || len(ids) < 1 {
-		return nil, err
+	c := b.Bucket(index).Cursor()
+
+	idx, _ := c.Seek(prefix)
+	for ; idx != nil && bytes.HasPrefix(idx, prefix); idx, _ = c.Next() {
+		if len(idx) == len(prefix)+idSize {
+			return idFromIndex(idx), nil
+		}
 	}
 
-	return ids[0], nil
+	return nil, nil
 }
 
 // MakeIndex creates the bolt database key for an index.
@@ -0,0 +1,162 @@
+// Package codec detects, reads and writes the compression formats supported
+// for inventories, source objects and destination objects.
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// None uncompressed
+	None = ""
+	// Gzip gzip members, RFC 1952
+	Gzip = "gzip"
+	// Zstd zstandard frames
+	Zstd = "zstd"
+	// Snappy the snappy framing format
+	Snappy = "snappy"
+)
+
+var (
+	// ErrInvalidCodec tells a caller that the compression codec is not
+	// supported
+	ErrInvalidCodec = errors.New("Invalid codec, expected \"gzip\", \"zstd\" or \"snappy\"")
+
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+	exts = map[string]string{
+		Gzip:   ".gz",
+		Zstd:   ".zst",
+		Snappy: ".sz",
+	}
+	contentEncodings = map[string]string{
+		Gzip:   "gzip",
+		Zstd:   "zstd",
+		Snappy: "x-snappy-framed",
+	}
+)
+
+// Validate checks that codec is supported, None included
+func Validate(codec string) error {
+	if _, ok := exts[codec]; codec != None && !ok {
+		return ErrInvalidCodec
+	}
+
+	return nil
+}
+
+// Ext the file extension of a codec, e.g. ".gz"
+func Ext(codec string) string {
+	return exts[codec]
+}
+
+// FromExt picks a codec from the file extension of a key or path
+func FromExt(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".gz"), strings.HasSuffix(key, ".gzip"):
+		return Gzip
+	case strings.HasSuffix(key, ".zst"), strings.HasSuffix(key, ".zstd"):
+		return Zstd
+	case strings.HasSuffix(key, ".sz"), strings.HasSuffix(key, ".snappy"):
+		return Snappy
+	}
+
+	return None
+}
+
+// ContentEncoding the HTTP Content-Encoding of a codec
+func ContentEncoding(codec string) string {
+	return contentEncodings[codec]
+}
+
+// Detect picks a codec from the header of a stream
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	case bytes.HasPrefix(header, snappyMagic):
+		return Snappy
+	}
+
+	return None
+}
+
+// Peek detects the codec of r without consuming it. The returned reader must
+// be used in place of r.
+func Peek(r io.Reader) (string, *bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(snappyMagic))
+	if err != nil && err != io.EOF {
+		return None, nil, err
+	}
+
+	return Detect(header), br, nil
+}
+
+// NewReader returns a reader of the decompressed contents of r
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case None:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{d}, nil
+	case Snappy:
+		return ioutil.NopCloser(snappy.NewReader(r)), nil
+	}
+
+	return nil, ErrInvalidCodec
+}
+
+// NewWriter returns a writer compressing to w. Closing it flushes the end of
+// the member or frame without closing w.
+func NewWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	case Snappy:
+		return snappy.NewBufferedWriter(w), nil
+	}
+
+	return nil, ErrInvalidCodec
+}
+
+// Decompress detects the codec of r from its header and returns a reader of
+// its decompressed contents. Uncompressed streams are returned as is.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	c, br, err := Peek(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReader(c, br)
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	input := []byte("a,b\n1,2\n")
+
+	for _, c := range []string{Gzip, Zstd, Snappy} {
+		t.Run(c, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(c, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write(input); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := Detect(buf.Bytes()); got != c {
+				t.Errorf("Detect() = %q, want %q", got, c)
+			}
+
+			r, err := Decompress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("Decompress() = %q, want %q", got, input)
+			}
+		})
+	}
+}
+
+func TestPeek(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{name: "empty", input: nil, want: None},
+		{name: "short", input: []byte{0x1f}, want: None},
+		{name: "gzip", input: []byte{0x1f, 0x8b, 0x08}, want: Gzip},
+		{name: "plain", input: []byte("plain text"), want: None},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, r, err := Peek(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Peek() = %q, want %q", got, tt.want)
+			}
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rest, tt.input) {
+				t.Errorf("Peek() consumed its input, got %q", rest)
+			}
+		})
+	}
+}
+
+func TestFromExt(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "a.json", want: None},
+		{key: "a.json.gz", want: Gzip},
+		{key: "a.gzip", want: Gzip},
+		{key: "a.zst", want: Zstd},
+		{key: "a.zstd", want: Zstd},
+		{key: "a.sz", want: Snappy},
+		{key: "a.snappy", want: Snappy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := FromExt(tt.key); got != tt.want {
+				t.Errorf("FromExt(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+			if tt.want != None && FromExt("a"+Ext(tt.want)) != tt.want {
+				t.Errorf("FromExt(Ext(%q)) != %q", tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		codec   string
+		wantErr error
+	}{
+		{codec: None},
+		{codec: Gzip},
+		{codec: Zstd},
+		{codec: Snappy},
+		{codec: "lz4", wantErr: ErrInvalidCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.codec, func(t *testing.T) {
+			if err := Validate(tt.codec); err != tt.wantErr {
+				t.Errorf("Validate(%q) = %v, want %v", tt.codec, err, tt.wantErr)
+			}
+		})
+	}
+}
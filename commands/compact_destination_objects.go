@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"s3fc/base"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"s3fc/planner"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// CompactDestinationObjects queries for IN_SYNC destination objects smaller
+// than MaxSize and plans their sources into new destination objects packed
+// with the object set's current BlockSize. Like PlanNewObjects this only
+// updates the model's state, the original destination objects are expired
+// once the new destination objects are IN_SYNC. MaxSize defaults to the
+// object set's BlockSize.
+type CompactDestinationObjects struct {
+	Bucket  string `json:"bucket"`
+	Prefix  string `json:"prefix"`
+	MaxSize int64  `json:"max_size,omitempty"`
+
+	db *bolt.DB
+}
+
+// Invoke triggers the CompactDestinationObjects command
+func (c CompactDestinationObjects) Invoke(ctx context.Context) error {
+	set := models.NewObjectSet(c.Bucket, c.Prefix)
+
+	var sources []planner.Source
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+
+		maxSize := c.MaxSize
+		if maxSize == 0 {
+			maxSize = set.BlockSize
+		}
+
+		dests, err := planner.Undersized(b, *set, maxSize)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range dests {
+			destSources, err := planner.DestinationSources(b, *set, d.ID)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, destSources...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	blocks := planner.Merges(planner.Pack(*set, sources))
+
+	return writeBlocks(c.db, *set, blocks, time.Now())
+}
+
+// Dependencies initializes a new command instance for invocation
+func (c *CompactDestinationObjects) Dependencies(
+	container base.Container,
+) (err error) {
+	c.db, err = container.DB()
+
+	return err
+}
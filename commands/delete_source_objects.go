@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"s3fc/base"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"s3fc/planner"
+	"s3fc/s3"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrDeleteSourcesDisabled tells a caller that the object set does not
+	// delete source objects
+	ErrDeleteSourcesDisabled = errors.New("object set does not delete source objects, see delete_sources")
+)
+
+// DeleteSourceObjects deletes the source objects of IN_SYNC destination objects
+// that were verified after they were written, once the object set's retention
+// has passed, and flags them as DELETED. Limit caps the number of source
+// objects deleted by an invocation, the source objects of a destination object
+// are always deleted together.
+type DeleteSourceObjects struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit,omitempty"`
+
+	client s3iface.S3API
+	db     *bolt.DB
+	logger logrus.FieldLogger
+}
+
+// Invoke triggers the DeleteSourceObjects command
+func (d DeleteSourceObjects) Invoke(ctx context.Context) error {
+	set := models.NewObjectSet(d.Bucket, d.Prefix)
+
+	var ids [][]byte
+	var sources []models.SourceObject
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+		if !set.DeleteSources {
+			return ErrDeleteSourcesDisabled
+		}
+
+		ids, sources, err = planner.Deletable(b, *set, time.Now(), d.Limit)
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	deleted, failed, deleteErr := s3.DeleteSourceObjects(ctx, d.client, set.Bucket, sources)
+	for _, f := range failed {
+		d.logger.WithFields(logrus.Fields{
+			"key":   aws.StringValue(sources[f.Index].Key),
+			"error": f.Err,
+		}).Warn("failed to delete source object")
+	}
+
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		for _, i := range deleted {
+			current := &sources[i]
+			obj, err := current.Copy()
+			if err != nil {
+				return err
+			}
+			obj.State = models.StateDeleted
+			if err = boltdb.UpdateRow(b, ids[i], obj, current); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	d.logger.WithFields(logrus.Fields{
+		"deleted": len(deleted),
+		"failed":  len(failed),
+	}).Info("deleted source objects")
+
+	return deleteErr
+}
+
+// Dependencies initializes a new command instance for invocation
+func (d *DeleteSourceObjects) Dependencies(
+	c base.Container,
+) (err error) {
+	d.client, err = c.S3API()
+	if err != nil {
+		return err
+	}
+	d.db, err = c.DB()
+	d.logger = c.Logger()
+
+	return err
+}
@@ -2,29 +2,51 @@ package commands
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"s3fc/base"
 	"s3fc/boltdb"
+	"s3fc/inventory"
 	"s3fc/models"
 
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrIncompleteInventory tells a caller that the inventory's manifest is a
+	// checkpoint of an inventory that has not finished being taken
+	ErrIncompleteInventory = errors.New("Inventory is incomplete, resume TakeInventory before loading it")
 )
 
 // LoadInventory command that loads the inject db with source data from either
-// a s3 object or a file on disk
+// a s3 object or a file on disk. Only the current version of an object is
+// loaded, delete markers flag their source object as deleted. When PartFiles
+// is set the source is a manifest and every part it lists is loaded.
+//
+// Objects that do not pass the object set's KeyFilter are still loaded but
+// flagged as FILTERED so that they are counted by GetSourceStats.
 type LoadInventory struct {
 	Bucket string `json:"bucket"`
 	Prefix string `json:"prefix"`
 
-	Source *string `json:"source,omitempty"`
+	Source    *string `json:"source,omitempty"`
+	PartFiles bool    `json:"part_files,omitempty"`
 
 	db        *bolt.DB
 	client    s3iface.S3API
 	inventory base.InventoryManager
+	logger    logrus.FieldLogger
+}
+
+// loadStats counts what was done with the objects of an inventory
+type loadStats struct {
+	added    int
+	updated  int
+	filtered int
+	deleted  int
 }
 
 // Invoke triggers the LoadInventory command
@@ -38,12 +60,31 @@ func (l LoadInventory) Invoke(parent context.Context) error {
 	}
 
 	objectSet := *models.NewObjectSet(l.Bucket, l.Prefix)
-	buf := make([]s3.Object, 0, 2048)
+	if err = l.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(objectSet.Name()) == nil {
+			return fmt.Errorf("Bucket not found: %s", string(objectSet.Name()))
+		}
+		_, err := boltdb.LookupTable(tx, &objectSet)
+		return err
+	}); err != nil {
+		return err
+	}
+	// create the columns added since the object set was put, e.g. version_id
+	if err = boltdb.EnsureTable(l.db, &objectSet); err != nil {
+		return err
+	}
+
+	var stats loadStats
+	buf := make([]inventory.Object, 0, 2048)
+
+	err = r.forEach(sourceCtx, func(ctx context.Context, o inventory.Object) error {
+		if !o.IsCurrent() {
+			return nil
+		}
 
-	err = r.forEach(sourceCtx, func(ctx context.Context, o s3.Object) error {
 		buf = append(buf, o)
 		if len(buf) == cap(buf) {
-			buf, err = l.flushBuffer(objectSet, buf)
+			buf, err = l.flushBuffer(objectSet, buf, &stats)
 			if err != nil {
 				return err
 			}
@@ -55,8 +96,18 @@ func (l LoadInventory) Invoke(parent context.Context) error {
 		return err
 	}
 
-	_, err = l.flushBuffer(objectSet, buf)
-	return err
+	if _, err = l.flushBuffer(objectSet, buf, &stats); err != nil {
+		return err
+	}
+
+	l.logger.WithFields(logrus.Fields{
+		"added":    stats.added,
+		"updated":  stats.updated,
+		"filtered": stats.filtered,
+		"deleted":  stats.deleted,
+	}).Info("loaded inventory")
+
+	return nil
 }
 
 // Dependencies initializes a new command instance for invocation
@@ -69,6 +120,7 @@ func (l *LoadInventory) Dependencies(
 	}
 	l.db, err = c.DB()
 	l.inventory = c.InventoryManager()
+	l.logger = c.Logger()
 
 	return err
 }
@@ -80,14 +132,27 @@ func (l *LoadInventory) resolveSource(ctx context.Context) (*objectReader, error
 	}
 
 	r, w := io.Pipe()
-	go l.inventory.ReadTo(ctx, w, *l.Source)
+	if !l.PartFiles {
+		go l.inventory.ReadTo(ctx, w, *l.Source)
+		return &objectReader{r}, nil
+	}
+
+	manifest, err := inventory.ReadManifest(ctx, l.inventory, *l.Source)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.Complete {
+		return nil, ErrIncompleteInventory
+	}
+	go inventory.ReadParts(ctx, l.inventory, w, manifest)
 	return &objectReader{r}, nil
 }
 
 func (l *LoadInventory) flushBuffer(
 	objectSet models.ObjectSet,
-	buf []s3.Object,
-) ([]s3.Object, error) {
+	buf []inventory.Object,
+	stats *loadStats,
+) ([]inventory.Object, error) {
 	if err := l.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectSet.Name())
 		if b == nil {
@@ -95,13 +160,34 @@ func (l *LoadInventory) flushBuffer(
 		}
 		for _, i := range buf {
 			obj := models.NewSourceObject(objectSet)
-			obj.Object.Object = i
+			obj.Object.Object = i.Object
+			obj.VersionID = i.VersionID
 
 			id, err := boltdb.LookupID(b, obj)
 			if err != nil {
 				return err
 			}
 
+			if i.IsDeleted() {
+				if id == nil {
+					continue
+				}
+				if err = l.deleteSource(b, id, objectSet); err != nil {
+					return err
+				}
+				stats.deleted++
+				continue
+			}
+
+			state := models.StateNew
+			if objectSet.Filter != nil {
+				_, key := obj.PK()
+				if !objectSet.Filter.Match(string(key), obj.Object.Object) {
+					state = models.StateFiltered
+					stats.filtered++
+				}
+			}
+
 			if id != nil {
 				current := models.NewSourceObject(obj.Parent)
 				if err = boltdb.LookupRow(b, id, current); err != nil {
@@ -109,17 +195,27 @@ func (l *LoadInventory) flushBuffer(
 				}
 
 				if !obj.IsDirty(current.Object) {
+					if err = l.refilterSource(b, id, current, state); err != nil {
+						return err
+					}
 					continue
 				}
+				// a changed source that no longer passes the filter is
+				// FILTERED rather than DIRTY
+				if state == models.StateFiltered {
+					obj.State = state
+				}
 
 				if err = boltdb.UpdateRow(b, id, obj, current); err != nil {
 					return err
 				}
+				stats.updated++
 			} else {
-				obj.State = models.StateNew
+				obj.State = state
 				if _, err = boltdb.AppendRow(b, obj); err != nil {
 					return err
 				}
+				stats.added++
 			}
 		}
 		return nil
@@ -130,19 +226,68 @@ func (l *LoadInventory) flushBuffer(
 	return buf[0:0], nil
 }
 
+// refilterSource applies changes to an object set's KeyFilter to a source
+// object that has not changed. NEW sources that no longer pass the filter are
+// FILTERED and FILTERED sources that now pass it are NEW again, sources in any
+// other state are left as they are.
+func (l *LoadInventory) refilterSource(
+	b *bolt.Bucket,
+	id []byte,
+	current *models.SourceObject,
+	state uint16,
+) error {
+	if current.State == state ||
+		(current.State != models.StateNew && current.State != models.StateFiltered) {
+		return nil
+	}
+
+	obj, err := current.Copy()
+	if err != nil {
+		return err
+	}
+	obj.State = state
+
+	return boltdb.UpdateRow(b, id, obj, current)
+}
+
+// deleteSource flags a source object as deleted when its key's current
+// version is a delete marker.
+func (l *LoadInventory) deleteSource(
+	b *bolt.Bucket,
+	id []byte,
+	objectSet models.ObjectSet,
+) error {
+	current := models.NewSourceObject(objectSet)
+	if err := boltdb.LookupRow(b, id, current); err != nil {
+		return err
+	}
+
+	if current.State == models.StateDeleted {
+		return nil
+	}
+
+	obj, err := current.Copy()
+	if err != nil {
+		return err
+	}
+	obj.State = models.StateDeleted
+
+	return boltdb.UpdateRow(b, id, obj, current)
+}
+
 type objectReader struct {
 	*io.PipeReader
 }
 
-type objectHandler func(context.Context, s3.Object) error
+type objectHandler func(context.Context, inventory.Object) error
 
 func (r *objectReader) forEach(
 	ctx context.Context,
 	f objectHandler,
 ) error {
-	var o s3.Object
+	var o inventory.Object
 
-	dec := json.NewDecoder(r)
+	dec := inventory.NewDecoder(r)
 	err := dec.Decode(&o)
 	for ; err == nil; err = dec.Decode(&o) {
 
@@ -150,12 +295,7 @@ func (r *objectReader) forEach(
 			r.CloseWithError(nestedErr)
 			return nestedErr
 		}
-		o.ETag = nil
-		o.Key = nil
-		o.LastModified = nil
-		o.Owner = nil
-		o.Size = nil
-		o.StorageClass = nil
+		o = inventory.Object{}
 	}
 
 	if err == io.EOF {
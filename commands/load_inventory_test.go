@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"s3fc/boltdb"
+	"s3fc/inventory"
+	"s3fc/logging"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/boltdb/bolt"
+)
+
+// testDB opens a new bolt database in a temporary file
+func testDB(t *testing.T) (*bolt.DB, func()) {
+	f, err := ioutil.TempFile("", "s3fc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// memoryInventory an InventoryManager keeping inventories in memory
+type memoryInventory map[string][]byte
+
+func (m memoryInventory) WriteFrom(
+	ctx context.Context,
+	r *io.PipeReader,
+	destination string,
+) error {
+	b, err := ioutil.ReadAll(r)
+	m[destination] = b
+	return err
+}
+
+func (m memoryInventory) ReadTo(
+	ctx context.Context,
+	w *io.PipeWriter,
+	source string,
+) error {
+	b, ok := m[source]
+	if !ok {
+		w.CloseWithError(os.ErrNotExist)
+		return os.ErrNotExist
+	}
+	_, err := io.Copy(w, bytes.NewReader(b))
+	w.CloseWithError(err)
+	return err
+}
+
+func inventoryObject(key string, etag string) inventory.Object {
+	return inventory.Object{Object: s3.Object{
+		Key:          aws.String("p/" + key),
+		ETag:         aws.String(etag),
+		Size:         aws.Int64(4),
+		LastModified: aws.Time(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}}
+}
+
+// load loads objects as the inventory of bucket b and prefix p/
+func load(t *testing.T, db *bolt.DB, objects []inventory.Object) {
+	var buf bytes.Buffer
+	enc := inventory.NewEncoder(&buf, true)
+	for _, o := range objects {
+		if err := enc.Encode(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := logging.New()
+	logging.SetOutput(logger, ioutil.Discard)
+	l := LoadInventory{
+		Bucket:    "b",
+		Prefix:    "p/",
+		Source:    aws.String("inventory"),
+		db:        db,
+		inventory: memoryInventory{"inventory": buf.Bytes()},
+		logger:    logger,
+	}
+	if err := l.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sourceStates the state of every source object by key
+func sourceStates(t *testing.T, db *bolt.DB) map[string]string {
+	states := make(map[string]string)
+	if err := db.View(func(tx *bolt.Tx) error {
+		set := models.NewObjectSet("b", "p/")
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+		c := b.Bucket(models.NewSourceObject(*set).Schema()["is_source_object"]).Cursor()
+		for id, _ := c.First(); id != nil; id, _ = c.Next() {
+			row := models.NewSourceObject(*set)
+			if err = boltdb.LookupRow(b, id, row); err != nil {
+				return err
+			}
+			states[aws.StringValue(row.Key)] = models.State(row.State).String()
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return states
+}
+
+func TestLoadInventory(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+
+	put := PutObjectSet{Bucket: "b", Prefix: "p/", Delimiter: aws.String("\n"), db: db}
+	if err := put.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := func(key string) inventory.Object {
+		return inventory.NewDeleteMarkerObject(&s3.DeleteMarkerEntry{
+			Key:      aws.String("p/" + key),
+			IsLatest: aws.Bool(true),
+		})
+	}
+	loads := []struct {
+		objects []inventory.Object
+		want    map[string]string
+	}{
+		{
+			objects: []inventory.Object{
+				inventoryObject("a", "1"),
+				inventoryObject("b", "1"),
+				inventoryObject("c", "1"),
+				inventoryObject("c.txt", "1"),
+			},
+			want: map[string]string{
+				"p/a":     "NEW",
+				"p/b":     "NEW",
+				"p/c":     "NEW",
+				"p/c.txt": "NEW",
+			},
+		},
+		{
+			objects: []inventory.Object{
+				inventoryObject("a", "1"),
+				inventoryObject("b", "2"),
+				deleted("c"),
+				inventoryObject("c.txt", "1"),
+				inventoryObject("d", "1"),
+			},
+			want: map[string]string{
+				"p/a":     "NEW",
+				"p/b":     "DIRTY",
+				"p/c":     "DELETED",
+				"p/c.txt": "NEW",
+				"p/d":     "NEW",
+			},
+		},
+		{
+			// a source recreated with the same ETag is no longer deleted
+			objects: []inventory.Object{inventoryObject("c", "1")},
+			want: map[string]string{
+				"p/a":     "NEW",
+				"p/b":     "DIRTY",
+				"p/c":     "DIRTY",
+				"p/c.txt": "NEW",
+				"p/d":     "NEW",
+			},
+		},
+	}
+
+	for i, l := range loads {
+		load(t, db, l.objects)
+		if got := sourceStates(t, db); !reflect.DeepEqual(got, l.want) {
+			t.Errorf("load %d = %v, want %v", i, got, l.want)
+		}
+	}
+}
+
+func TestLoadInventoryFilter(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+
+	loads := []struct {
+		exclude []string
+		objects []inventory.Object
+		want    map[string]string
+	}{
+		{
+			exclude: []string{"*.tmp"},
+			objects: []inventory.Object{inventoryObject("a", "1"), inventoryObject("b.tmp", "1")},
+			want:    map[string]string{"p/a": "NEW", "p/b.tmp": "FILTERED"},
+		},
+		{
+			// sources that pass a changed filter are NEW again
+			objects: []inventory.Object{inventoryObject("a", "1"), inventoryObject("b.tmp", "1")},
+			want:    map[string]string{"p/a": "NEW", "p/b.tmp": "NEW"},
+		},
+		{
+			// changed sources that fail the filter are FILTERED, not DIRTY
+			exclude: []string{"*.tmp"},
+			objects: []inventory.Object{inventoryObject("a", "2"), inventoryObject("b.tmp", "2")},
+			want:    map[string]string{"p/a": "DIRTY", "p/b.tmp": "FILTERED"},
+		},
+	}
+
+	for i, l := range loads {
+		put := PutObjectSet{
+			Bucket:    "b",
+			Prefix:    "p/",
+			Delimiter: aws.String("\n"),
+			KeyFilter: models.KeyFilter{Exclude: l.exclude},
+			db:        db,
+		}
+		if err := put.Invoke(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		load(t, db, l.objects)
+		if got := sourceStates(t, db); !reflect.DeepEqual(got, l.want) {
+			t.Errorf("load %d = %v, want %v", i, got, l.want)
+		}
+	}
+}
@@ -2,128 +2,144 @@ package commands
 
 import (
 	"context"
-	"path"
+	"encoding/json"
+	"io"
 	"s3fc/base"
 	"s3fc/boltdb"
 	"s3fc/models"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/google/uuid"
+	"s3fc/planner"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
 // PlanNewObjects queries for NEW source objects and builds out new destination
 // objects. This only updates the model's state and does not actually
-// concatinate the source objects to the destination objects
+// concatinate the source objects to the destination objects. Sources are read
+// and packed in batches of 2048, and planned separately per partition of the
+// object set. Destination objects smaller than the object set's MinBlockSize
+// are not planned and their sources stay NEW until they grow large enough or
+// reach the MaxAge. When the object set tops up, the sources of the most recent
+// under-filled destination object of a partition are planned again ahead of
+// its new sources.
+//
+// MaxDestinations and MaxBytes bound the work planned by a single invocation,
+// sources beyond them stay NEW and no further sources are read once either is
+// spent. The first destination object of a batch is always planned while the
+// bounds are not spent, so MaxBytes may be exceeded by one destination object.
+// The command reports what was planned and whether work remains so that a
+// driver can plan a job in bounded batches.
 type PlanNewObjects struct {
 	Bucket string `json:"bucket"`
 	Prefix string `json:"prefix"`
 
-	db *bolt.DB
+	MaxDestinations int   `json:"max_destinations,omitempty"`
+	MaxBytes        int64 `json:"max_bytes,omitempty"`
+
+	db     *bolt.DB
+	output PlanNewObjectsOutput
+}
+
+// PlanNewObjectsOutput the output of the command
+type PlanNewObjectsOutput struct {
+	Destinations int   `json:"destinations"`
+	Sources      int   `json:"sources"`
+	Bytes        int64 `json:"bytes"`
+	Remaining    bool  `json:"remaining"`
 }
 
 // Invoke triggers the PlanNewObjects command
-func (p PlanNewObjects) Invoke(ctx context.Context) error {
+func (p *PlanNewObjects) Invoke(ctx context.Context) error {
 	set := models.NewObjectSet(p.Bucket, p.Prefix)
 
+	var plan *planner.Planner
 	if err := p.db.View(func(tx *bolt.Tx) error {
-		_, err := boltdb.LookupTable(tx, set)
+		b, err := boltdb.LookupTable(tx, set)
 		if err != nil {
 			return err
 		}
-		return nil
+
+		plan, err = planner.New(b, *set, models.StateNew)
+		return err
 	}); err != nil {
 		return err
 	}
 
-	index := []byte("idx_source_state")
-	prefix := boltdb.Uint16tol(models.StateNew)
-	limit := 2048
-
-	var blockID []byte
-	var block *models.DestinationObject
-	var n int64
-	var objectIds [][]byte
-	var err error
-
-	for {
-		objectIds = nil
+	plannedAt := time.Now()
+	for !p.output.Remaining {
+		if _, _, ok := p.budget(); !ok {
+			p.output.Remaining = true
+			break
+		}
+		if plan.Done() {
+			return p.write(*set, plan.Close(plannedAt), plannedAt)
+		}
 
+		var blocks []planner.Block
 		if err := p.db.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket(set.Name())
-			ids, err := boltdb.PrefixQuery(
-				b, index, prefix, limit, nil,
-			)
-			if err != nil {
-				return err
-			}
-
-			objectIds = ids
-			return nil
+			var err error
+			blocks, err = plan.Next(tx.Bucket(set.Name()))
+			return err
 		}); err != nil {
 			return err
 		}
 
-		if err := p.db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket(set.Name())
-			for _, id := range objectIds {
-				source := models.NewSourceObject(*set)
-				if err = boltdb.LookupRow(b, id, source); err != nil {
-					return err
-				}
+		if err := p.write(*set, blocks, plannedAt); err != nil {
+			return err
+		}
+	}
 
-				if blockID == nil {
-					n = 0
-					block = models.NewDestinationObject(*set)
-					block.Key = aws.String(path.Join(
-						block.Parent.DestinationPath,
-						uuid.Must(uuid.NewRandom()).String(),
-					))
-					block.State = models.StateNew
-
-					blockID, err = boltdb.AppendRow(b, block)
-					if err != nil {
-						return err
-					}
-				}
+	return nil
+}
 
-				current, err := source.Copy()
-				if err != nil {
-					return err
-				}
-				source.DestinationObjectID = blockID
-				source.State = models.StateInSync
-				if err = boltdb.UpdateRow(b, id, source, current); err != nil {
-					return err
-				}
+// Report writes what the command planned as JSON
+func (p *PlanNewObjects) Report(w io.Writer) error {
+	return json.NewEncoder(w).Encode(p.output)
+}
 
-				n += aws.Int64Value(source.Size) + int64(len(set.Delimiter))
-				if n >= set.BlockSize {
-					if err = p.flushDestination(b, blockID, block, n); err != nil {
-						return err
-					}
-					blockID = nil
-					block = nil
-					n = 0
-				}
-			}
+// write writes the blocks that fit in what is left of MaxDestinations and
+// MaxBytes, and flags the output as remaining when any block is left out
+func (p *PlanNewObjects) write(
+	set models.ObjectSet,
+	blocks []planner.Block,
+	plannedAt time.Time,
+) error {
+	maxDestinations, maxBytes, _ := p.budget()
+	blocks, remaining := planner.Limit(blocks, maxDestinations, maxBytes)
+	if err := writeBlocks(p.db, set, blocks, plannedAt); err != nil {
+		return err
+	}
 
-			if len(objectIds) < limit && blockID != nil {
-				if err = p.flushDestination(b, blockID, block, n); err != nil {
-					return err
-				}
-			}
+	output := &p.output
+	output.Destinations += len(blocks)
+	for _, block := range blocks {
+		output.Sources += len(block.Sources)
+		output.Bytes += block.Size
+	}
+	output.Remaining = remaining
 
-			return nil
-		}); err != nil {
-			return err
-		}
+	return nil
+}
 
-		if len(objectIds) < limit {
-			return nil
+// budget returns what is left of MaxDestinations and MaxBytes after the
+// destination objects planned so far, it reports false once either is spent
+func (p *PlanNewObjects) budget() (int, int64, bool) {
+	output := p.output
+	maxDestinations, maxBytes := p.MaxDestinations, p.MaxBytes
+	if maxDestinations > 0 {
+		maxDestinations -= output.Destinations
+		if maxDestinations <= 0 {
+			return 0, 0, false
 		}
 	}
+	if maxBytes > 0 {
+		maxBytes -= output.Bytes
+		if maxBytes <= 0 {
+			return 0, 0, false
+		}
+	}
+
+	return maxDestinations, maxBytes, true
 }
 
 // Dependencies initializes a new command instance for invocation
@@ -135,19 +151,31 @@ func (p *PlanNewObjects) Dependencies(
 	return err
 }
 
-func (p *PlanNewObjects) flushDestination(
-	b *bolt.Bucket,
-	blockID []byte,
-	block *models.DestinationObject,
-	n int64,
+// writeBlocks writes planned blocks as destination objects in transactions of
+// about 2048 sources each
+func writeBlocks(
+	db *bolt.DB,
+	set models.ObjectSet,
+	blocks []planner.Block,
+	plannedAt time.Time,
 ) error {
-	newBlock, err := block.Copy()
-	if err != nil {
-		return err
-	}
-	newBlock.Size = aws.Int64(n)
-	if err = boltdb.UpdateRow(b, blockID, newBlock, block); err != nil {
-		return err
+	limit := 2048
+	for len(blocks) > 0 {
+		n, written := 0, 0
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(set.Name())
+			for ; n < len(blocks) && written < limit; n++ {
+				if _, err := planner.Write(b, set, blocks[n], plannedAt); err != nil {
+					return err
+				}
+				written += len(blocks[n].Sources)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		blocks = blocks[n:]
 	}
 
 	return nil
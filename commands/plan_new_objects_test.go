@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+func TestPlanNewObjects(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+
+	putJSON(t, db, `{
+		"bucket": "b",
+		"prefix": "p/",
+		"destination_bucket": "d",
+		"destination_path": "out",
+		"block_size": 8,
+		"delimiter": ""
+	}`)
+	set := lookupObjectSet(t, db)
+
+	n := 5000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		for i := 0; i < n; i++ {
+			row := models.NewSourceObject(*set)
+			row.Key = aws.String(fmt.Sprintf("p/%05d", i))
+			row.ETag = aws.String("etag")
+			row.Size = aws.Int64(4)
+			row.LastModified = aws.Time(time.Now())
+			row.State = models.StateNew
+			if _, err := boltdb.AppendRow(b, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		plan PlanNewObjects
+		want PlanNewObjectsOutput
+	}{
+		{
+			name: "max destinations",
+			plan: PlanNewObjects{MaxDestinations: 1},
+			want: PlanNewObjectsOutput{1, 2, 8, true},
+		},
+		{
+			name: "max bytes",
+			plan: PlanNewObjects{MaxBytes: 20},
+			want: PlanNewObjectsOutput{2, 4, 16, true},
+		},
+		{
+			name: "first destination over max bytes",
+			plan: PlanNewObjects{MaxBytes: 1},
+			want: PlanNewObjectsOutput{1, 2, 8, true},
+		},
+	}
+
+	planned := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.plan
+			p.Bucket, p.Prefix, p.db = set.Bucket, set.Prefix, db
+			if err := p.Invoke(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if p.output != tt.want {
+				t.Errorf("PlanNewObjects() = %+v, want %+v", p.output, tt.want)
+			}
+			planned += p.output.Sources
+		})
+	}
+
+	// planning in bounded batches until no work remains plans every source
+	for remaining := true; remaining; {
+		p := PlanNewObjects{
+			Bucket:          set.Bucket,
+			Prefix:          set.Prefix,
+			MaxDestinations: 1000,
+			db:              db,
+		}
+		if err := p.Invoke(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if p.output.Destinations > 1000 {
+			t.Fatalf("PlanNewObjects() planned %d destinations", p.output.Destinations)
+		}
+		planned += p.output.Sources
+		remaining = p.output.Remaining
+	}
+	if planned != n {
+		t.Errorf("planned %d sources, want %d", planned, n)
+	}
+}
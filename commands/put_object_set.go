@@ -3,12 +3,15 @@ package commands
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"s3fc/base"
 	"s3fc/boltdb"
 	"s3fc/models"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/boltdb/bolt"
 )
 
@@ -19,27 +22,146 @@ var (
 )
 
 // PutObjectSet ensures an object set exists in a bolt database and will also
-// update schema, indexes, and metadata
+// update schema, indexes, and metadata. The embedded KeyFilter limits which
+// objects under the prefix are loaded as source objects and the embedded
+// Partitioning groups them into partitions of destination objects.
+//
+// When it is decoded from JSON only the options present in its input are
+// updated, the others keep the values stored with the object set.
 type PutObjectSet struct {
-	Bucket            string  `json:"bucket"`
-	Prefix            string  `json:"prefix"`
-	DestinationBucket string  `json:"destination_bucket"`
-	DestinationPath   string  `json:"destination_path"`
-	BlockSize         int64   `json:"block_size"`
-	Delimiter         *string `json:"delimiter,omitempty"`
-	DelimiterB64      *string `json:"delimiter_b64,omitempty"`
-
-	db *bolt.DB
+	Bucket             string  `json:"bucket"`
+	Prefix             string  `json:"prefix"`
+	DestinationBucket  string  `json:"destination_bucket"`
+	DestinationPath    string  `json:"destination_path"`
+	BlockSize          int64   `json:"block_size"`
+	Delimiter          *string `json:"delimiter,omitempty"`
+	DelimiterB64       *string `json:"delimiter_b64,omitempty"`
+	DelimiterMode      string  `json:"delimiter_mode,omitempty"`
+	StripBOM           bool    `json:"strip_bom,omitempty"`
+	DelimiterPlacement string  `json:"delimiter_placement,omitempty"`
+	Header             *string `json:"header,omitempty"`
+	HeaderB64          *string `json:"header_b64,omitempty"`
+	Footer             *string `json:"footer,omitempty"`
+	FooterB64          *string `json:"footer_b64,omitempty"`
+	KeyTemplate        string  `json:"key_template,omitempty"`
+	DeterministicKeys  bool    `json:"deterministic_keys,omitempty"`
+	Packing            string  `json:"packing,omitempty"`
+	SortBy             string  `json:"sort_by,omitempty"`
+	MaxSources         int64   `json:"max_sources_per_destination,omitempty"`
+	MinBlockSize       int64   `json:"min_block_size,omitempty"`
+	MaxAge             string  `json:"max_age,omitempty"`
+	TopUp              bool    `json:"top_up,omitempty"`
+	GzipMembers        bool    `json:"gzip_members,omitempty"`
+	Codec              string  `json:"codec,omitempty"`
+	BlockSizeTarget    string  `json:"block_size_target,omitempty"`
+	CompressionRatio   float64 `json:"compression_ratio,omitempty"`
+	CSVHeader          bool    `json:"csv_header,omitempty"`
+	HeaderMismatch     string  `json:"header_mismatch,omitempty"`
+	JSONMode           string  `json:"json_mode,omitempty"`
+	Archive            string  `json:"archive,omitempty"`
+	Framing            string  `json:"framing,omitempty"`
+	FrameCRC           bool    `json:"frame_crc,omitempty"`
+	Index              string  `json:"index,omitempty"`
+	DeleteSources      bool    `json:"delete_sources,omitempty"`
+	Retention          string  `json:"retention,omitempty"`
+
+	Transforms models.Transforms `json:"transforms,omitempty"`
+
+	models.KeyFilter
+	models.Partitioning
+
+	input json.RawMessage
+	db    *bolt.DB
+}
+
+// putObjectSet decodes a PutObjectSet without its UnmarshalJSON method
+type putObjectSet PutObjectSet
+
+// UnmarshalJSON decodes a PutObjectSet and keeps its input, so that Invoke can
+// tell the options that are missing from the ones that were set
+func (p *PutObjectSet) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*putObjectSet)(p)); err != nil {
+		return err
+	}
+	p.input = append(json.RawMessage(nil), data...)
+
+	return nil
 }
 
 // Invoke triggers the PutObjectSet command
 func (p PutObjectSet) Invoke(ctx context.Context) error {
 	var err error
+	if p.input != nil {
+		if p, err = p.stored(); err != nil {
+			return err
+		}
+	}
+
 	objectSet := models.NewObjectSet(p.Bucket, p.Prefix)
 
 	objectSet.BlockSize = p.BlockSize
 	objectSet.DestinationBucket = p.DestinationBucket
 	objectSet.DestinationPath = strings.Trim(p.DestinationPath, "/") + "/"
+	objectSet.KeyTemplate = p.KeyTemplate
+	objectSet.DeterministicKeys = p.DeterministicKeys
+	if err = objectSet.ValidateKeyTemplate(); err != nil {
+		return err
+	}
+	objectSet.Packing = p.Packing
+	objectSet.SortBy = p.SortBy
+	objectSet.MaxSources = p.MaxSources
+	objectSet.MinBlockSize = p.MinBlockSize
+	objectSet.TopUp = p.TopUp
+	objectSet.GzipMembers = p.GzipMembers
+	objectSet.Codec = p.Codec
+	objectSet.BlockSizeTarget = p.BlockSizeTarget
+	objectSet.CompressionRatio = p.CompressionRatio
+	if err = objectSet.ValidateCodec(); err != nil {
+		return err
+	}
+	objectSet.CSVHeader = p.CSVHeader
+	objectSet.HeaderMismatch = p.HeaderMismatch
+	if err = objectSet.ValidateCSV(); err != nil {
+		return err
+	}
+	objectSet.JSONMode = p.JSONMode
+	if err = objectSet.ValidateJSON(); err != nil {
+		return err
+	}
+	objectSet.Archive = p.Archive
+	if err = objectSet.ValidateArchive(); err != nil {
+		return err
+	}
+	objectSet.Framing = p.Framing
+	objectSet.FrameCRC = p.FrameCRC
+	if err = objectSet.ValidateFraming(); err != nil {
+		return err
+	}
+	objectSet.Index = p.Index
+	if err = objectSet.ValidateIndex(); err != nil {
+		return err
+	}
+	objectSet.Transforms = p.Transforms
+	if err = objectSet.ValidateTransforms(); err != nil {
+		return err
+	}
+	if p.MaxAge != "" {
+		if objectSet.MaxAge, err = time.ParseDuration(p.MaxAge); err != nil {
+			return err
+		}
+	}
+	if err = objectSet.ValidatePlanning(); err != nil {
+		return err
+	}
+	objectSet.DeleteSources = p.DeleteSources
+	if p.Retention != "" {
+		if objectSet.Retention, err = time.ParseDuration(p.Retention); err != nil {
+			return err
+		}
+	}
+	if err = objectSet.ValidateMove(); err != nil {
+		return err
+	}
 
 	if p.Delimiter != nil {
 		objectSet.Delimiter = []byte(*p.Delimiter)
@@ -51,6 +173,32 @@ func (p PutObjectSet) Invoke(ctx context.Context) error {
 	} else {
 		return ErrMissingDelimeter
 	}
+	objectSet.DelimiterMode = p.DelimiterMode
+	objectSet.StripBOM = p.StripBOM
+	objectSet.DelimiterPlacement = p.DelimiterPlacement
+	if err = objectSet.ValidateDelimiter(); err != nil {
+		return err
+	}
+	if objectSet.Header, err = decodeBytes(p.Header, p.HeaderB64); err != nil {
+		return err
+	}
+	if objectSet.Footer, err = decodeBytes(p.Footer, p.FooterB64); err != nil {
+		return err
+	}
+
+	if !p.KeyFilter.IsEmpty() {
+		objectSet.Filter = &p.KeyFilter
+		if err = objectSet.Filter.Compile(); err != nil {
+			return err
+		}
+	}
+
+	if !p.Partitioning.IsEmpty() {
+		objectSet.Partitioning = &p.Partitioning
+		if err = objectSet.Partitioning.Compile(); err != nil {
+			return err
+		}
+	}
 
 	if err = boltdb.EnsureTable(p.db, objectSet); err != nil {
 		return err
@@ -64,7 +212,16 @@ func (p PutObjectSet) Invoke(ctx context.Context) error {
 			return err
 		}
 		for k, v := range values {
-			if err = b.Put(schema[k], v); err != nil {
+			if v == nil {
+				// bolt refuses to delete a missing key that sorts before a
+				// column bucket, so only delete what is there
+				if b.Get(schema[k]) != nil {
+					err = b.Delete(schema[k])
+				}
+			} else {
+				err = b.Put(schema[k], v)
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -80,3 +237,110 @@ func (p *PutObjectSet) Dependencies(
 
 	return err
 }
+
+// stored returns the object set stored in the bolt database with the options
+// of the command's input decoded over it, or the command itself when the
+// object set does not exist yet
+func (p PutObjectSet) stored() (PutObjectSet, error) {
+	objectSet := models.NewObjectSet(p.Bucket, p.Prefix)
+	found := false
+	if err := p.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(objectSet.Name()) == nil {
+			return nil
+		}
+		found = true
+		_, err := boltdb.LookupTable(tx, objectSet)
+		return err
+	}); err != nil || !found {
+		return p, err
+	}
+
+	stored := newPutObjectSet(*objectSet)
+	if err := json.Unmarshal(p.input, (*putObjectSet)(&stored)); err != nil {
+		return p, err
+	}
+	stored.db = p.db
+
+	return stored, nil
+}
+
+// newPutObjectSet maps an object set to the command that puts it as it is
+func newPutObjectSet(o models.ObjectSet) PutObjectSet {
+	p := PutObjectSet{
+		Bucket:             o.Bucket,
+		Prefix:             o.Prefix,
+		DestinationBucket:  o.DestinationBucket,
+		DestinationPath:    o.DestinationPath,
+		BlockSize:          o.BlockSize,
+		DelimiterB64:       encodeBytes(o.Delimiter),
+		DelimiterMode:      o.DelimiterMode,
+		StripBOM:           o.StripBOM,
+		DelimiterPlacement: o.DelimiterPlacement,
+		HeaderB64:          encodeBytes(o.Header),
+		FooterB64:          encodeBytes(o.Footer),
+		KeyTemplate:        o.KeyTemplate,
+		DeterministicKeys:  o.DeterministicKeys,
+		Packing:            o.Packing,
+		SortBy:             o.SortBy,
+		MaxSources:         o.MaxSources,
+		MinBlockSize:       o.MinBlockSize,
+		MaxAge:             formatDuration(o.MaxAge),
+		TopUp:              o.TopUp,
+		GzipMembers:        o.GzipMembers,
+		Codec:              o.Codec,
+		BlockSizeTarget:    o.BlockSizeTarget,
+		CompressionRatio:   o.CompressionRatio,
+		CSVHeader:          o.CSVHeader,
+		HeaderMismatch:     o.HeaderMismatch,
+		JSONMode:           o.JSONMode,
+		Archive:            o.Archive,
+		Framing:            o.Framing,
+		FrameCRC:           o.FrameCRC,
+		Index:              o.Index,
+		DeleteSources:      o.DeleteSources,
+		Retention:          formatDuration(o.Retention),
+		Transforms:         o.Transforms,
+	}
+	if p.DelimiterB64 == nil {
+		p.DelimiterB64 = aws.String("")
+	}
+	if o.Filter != nil {
+		p.KeyFilter = *o.Filter
+	}
+	if o.Partitioning != nil {
+		p.Partitioning = *o.Partitioning
+	}
+
+	return p
+}
+
+// encodeBytes the base64 parameter of optional bytes
+func encodeBytes(b []byte) *string {
+	if b == nil {
+		return nil
+	}
+
+	return aws.String(base64.StdEncoding.EncodeToString(b))
+}
+
+// formatDuration the parameter of an optional duration
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return d.String()
+}
+
+// decodeBytes returns the bytes of an optional parameter passed either as a
+// string or base64 encoded
+func decodeBytes(s *string, b64 *string) ([]byte, error) {
+	if s != nil {
+		return []byte(*s), nil
+	}
+	if b64 != nil {
+		return base64.StdEncoding.DecodeString(*b64)
+	}
+
+	return nil, nil
+}
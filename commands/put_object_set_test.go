@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+// lookupObjectSet loads the object set of bucket b and prefix p/
+func lookupObjectSet(t *testing.T, db *bolt.DB) *models.ObjectSet {
+	set := models.NewObjectSet("b", "p/")
+	if err := db.View(func(tx *bolt.Tx) error {
+		_, err := boltdb.LookupTable(tx, set)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return set
+}
+
+func TestPutObjectSet(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+
+	put := PutObjectSet{
+		Bucket:          "b",
+		Prefix:          "p/",
+		DestinationPath: "/out/",
+		BlockSize:       8,
+		Delimiter:       aws.String("\n"),
+		KeyTemplate:     "{{.Partition}}/{{.Sequence}}",
+		MaxAge:          "1h",
+		KeyFilter:       models.KeyFilter{Include: []string{"*.csv"}},
+		Partitioning:    models.Partitioning{By: models.PartitionByLastModified},
+		db:              db,
+	}
+	if err := put.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	set := lookupObjectSet(t, db)
+	if set.DestinationPath != "out/" || set.BlockSize != 8 ||
+		string(set.Delimiter) != "\n" || set.MaxAge != time.Hour ||
+		set.KeyTemplate != put.KeyTemplate {
+		t.Errorf("object set = %+v", set)
+	}
+	if set.Filter == nil || set.Partitioning == nil {
+		t.Fatalf("object set filter = %v, partitioning = %v", set.Filter, set.Partitioning)
+	}
+	if !reflect.DeepEqual(set.Filter.Include, []string{"*.csv"}) ||
+		set.Partitioning.By != models.PartitionByLastModified {
+		t.Errorf("object set filter = %+v, partitioning = %+v", set.Filter, set.Partitioning)
+	}
+
+	// putting it again without a filter or partitioning clears them
+	put.KeyFilter = models.KeyFilter{}
+	put.Partitioning = models.Partitioning{}
+	if err := put.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	set = lookupObjectSet(t, db)
+	if set.Filter != nil || set.Partitioning != nil {
+		t.Errorf("object set filter = %v, partitioning = %v, want none", set.Filter, set.Partitioning)
+	}
+}
+
+// putJSON decodes a PutObjectSet as the state machine passes it and invokes it
+func putJSON(t *testing.T, db *bolt.DB, input string) {
+	var put PutObjectSet
+	if err := json.Unmarshal([]byte(input), &put); err != nil {
+		t.Fatal(err)
+	}
+	put.db = db
+	if err := put.Invoke(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutObjectSetKeepsOptions(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+
+	putJSON(t, db, `{
+		"bucket": "b",
+		"prefix": "p/",
+		"destination_bucket": "d",
+		"destination_path": "out",
+		"block_size": 8,
+		"delimiter_b64": "AA==",
+		"header": "h",
+		"codec": "gzip",
+		"csv_header": true,
+		"key_template": "{{.UUID}}",
+		"max_age": "1h",
+		"delete_sources": true,
+		"retention": "24h",
+		"transforms": [{"op": "drop_empty"}],
+		"include": ["*.csv"],
+		"partition_by": "last_modified"
+	}`)
+
+	// the base fields of the state machine's job input
+	base := `{
+		"bucket": "b",
+		"prefix": "p/",
+		"destination_bucket": "d2",
+		"destination_path": "out",
+		"block_size": 16,
+		"delimiter": "\n"
+	}`
+	putJSON(t, db, base)
+
+	set := lookupObjectSet(t, db)
+	if set.DestinationBucket != "d2" || set.BlockSize != 16 ||
+		string(set.Delimiter) != "\n" {
+		t.Errorf("base fields = %q %d %q, want d2 16 newline",
+			set.DestinationBucket, set.BlockSize, set.Delimiter)
+	}
+	if string(set.Header) != "h" || set.Codec != "gzip" || !set.CSVHeader ||
+		set.KeyTemplate != "{{.UUID}}" || set.MaxAge != time.Hour ||
+		!set.DeleteSources || set.Retention != 24*time.Hour ||
+		len(set.Transforms) != 1 {
+		t.Errorf("options were not kept: %+v", set)
+	}
+	if set.Filter == nil || set.Partitioning == nil {
+		t.Errorf("filter = %v, partitioning = %v, want them kept", set.Filter, set.Partitioning)
+	}
+
+	// options present in the input are cleared
+	putJSON(t, db, `{
+		"bucket": "b",
+		"prefix": "p/",
+		"codec": "",
+		"include": null,
+		"partition_by": ""
+	}`)
+	set = lookupObjectSet(t, db)
+	if set.Codec != "" || set.Filter != nil || set.Partitioning != nil {
+		t.Errorf("codec = %q, filter = %v, partitioning = %v, want them cleared",
+			set.Codec, set.Filter, set.Partitioning)
+	}
+	if !set.CSVHeader || set.BlockSize != 16 {
+		t.Errorf("options were not kept: %+v", set)
+	}
+}
+
+func TestPutObjectSetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		put     PutObjectSet
+		wantErr error
+	}{
+		{name: "missing delimiter", put: PutObjectSet{}, wantErr: ErrMissingDelimeter},
+		{
+			name:    "constant key template",
+			put:     PutObjectSet{Delimiter: aws.String(""), KeyTemplate: "data"},
+			wantErr: models.ErrConstantKey,
+		},
+		{
+			name:    "header mismatch without csv header",
+			put:     PutObjectSet{Delimiter: aws.String(""), HeaderMismatch: "quarantine"},
+			wantErr: models.ErrHeaderMismatchWithoutCSV,
+		},
+		{
+			name: "json mode with transforms",
+			put: PutObjectSet{
+				Delimiter:  aws.String(""),
+				JSONMode:   models.JSONLines,
+				Transforms: models.Transforms{{Op: "drop_empty"}},
+			},
+			wantErr: models.ErrTransformMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, done := testDB(t)
+			defer done()
+
+			tt.put.Bucket = "b"
+			tt.put.Prefix = "p/"
+			tt.put.db = db
+			if err := tt.put.Invoke(context.Background()); err != tt.wantErr {
+				t.Errorf("Invoke() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
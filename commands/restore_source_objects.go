@@ -0,0 +1,202 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"s3fc/base"
+	"s3fc/boltdb"
+	"s3fc/lineage"
+	"s3fc/models"
+	"s3fc/s3"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoIndex tells a caller that the object set does not write sidecar
+	// indexes that source objects could be restored from
+	ErrNoIndex = errors.New("object set has no index to restore source objects from")
+	// ErrRestoreFailed tells a caller that some source objects could not be
+	// restored or verified
+	ErrRestoreFailed = errors.New("failed to restore source objects")
+)
+
+// RestoreSourceObjects reconstructs the source objects of a destination object
+// from the byte ranges recorded in its sidecar index. Source objects are found
+// by their destination object id and written back to their key, or to
+// TargetBucket and TargetPrefix in place of the object set's bucket and
+// prefix. Source objects whose restored bytes do not match their size or ETag
+// are logged and not written. Object sets that change the bytes of source
+// objects while merging them fail with models.ErrNotRestorable before any
+// source object is written.
+type RestoreSourceObjects struct {
+	Bucket       string  `json:"bucket"`
+	Prefix       string  `json:"prefix"`
+	ID           string  `json:"id"`
+	TargetBucket string  `json:"target_bucket,omitempty"`
+	TargetPrefix *string `json:"target_prefix,omitempty"`
+
+	client s3iface.S3API
+	db     *bolt.DB
+	logger logrus.FieldLogger
+}
+
+// Invoke triggers the RestoreSourceObjects command
+func (r RestoreSourceObjects) Invoke(ctx context.Context) error {
+	set := models.NewObjectSet(r.Bucket, r.Prefix)
+
+	id, err := base64.RawURLEncoding.DecodeString(r.ID)
+	if err != nil {
+		return err
+	}
+
+	var dest *models.DestinationObject
+	var sources []models.SourceObject
+	if err = r.db.View(func(tx *bolt.Tx) error {
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+
+		dest = models.NewDestinationObject(*set)
+		if err = boltdb.LookupRow(b, id, dest); err != nil {
+			return err
+		}
+
+		index := []byte("idx_destination")
+		prefix := id
+		limit := 2048
+
+		var exclusiveStart []byte
+		for running := true; running; {
+			ids, err := boltdb.PrefixQuery(
+				b, index, prefix, limit, exclusiveStart,
+			)
+			if err != nil {
+				return err
+			}
+
+			for _, sID := range ids {
+				source := models.NewSourceObject(*set)
+				if err = boltdb.LookupRow(b, sID, source); err != nil {
+					return err
+				}
+				if source.State == models.StateQuarantined {
+					continue
+				}
+
+				sources = append(sources, *source)
+			}
+
+			if len(ids) < limit {
+				running = false
+				continue
+			}
+
+			exclusiveStart = boltdb.MakeIndex(prefix, ids[len(ids)-1])
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if dest.Parent.Index == lineage.None {
+		return ErrNoIndex
+	}
+	if err = set.ValidateRestore(); err != nil {
+		return err
+	}
+	for _, source := range sources {
+		key := aws.StringValue(source.Key)
+		if err = set.ValidateRestoreSource(key); err != nil {
+			return fmt.Errorf("%w: %s", err, key)
+		}
+	}
+	entries, err := s3.ReadIndex(ctx, r.client, *dest)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string][]lineage.Entry)
+	for _, e := range entries {
+		byKey[e.Key] = append(byKey[e.Key], e)
+	}
+
+	bucket := set.Bucket
+	if r.TargetBucket != "" {
+		bucket = r.TargetBucket
+	}
+
+	var restored, failed int
+	for _, source := range sources {
+		key := aws.StringValue(source.Key)
+		logger := r.logger.WithField("key", key)
+
+		entry, ok := findEntry(byKey[key], aws.StringValue(source.ETag))
+		if !ok {
+			logger.Warn("source object is not in the destination object's index")
+			failed++
+			continue
+		}
+
+		target := key
+		if r.TargetPrefix != nil {
+			target = *r.TargetPrefix + set.EntryName(key)
+		}
+
+		err = s3.RestoreSource(ctx, r.client, *dest, source, entry, bucket, target)
+		if errors.Is(err, s3.ErrSizeMismatch) || errors.Is(err, s3.ErrETagMismatch) {
+			logger.WithError(err).Warn("source object failed verification")
+			failed++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"restored": restored,
+		"failed":   failed,
+	}).Info("restored source objects")
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRestoreFailed, failed, len(sources))
+	}
+	return nil
+}
+
+// findEntry picks the index entry of a source object, by ETag when a key was
+// written more than once
+func findEntry(entries []lineage.Entry, etag string) (lineage.Entry, bool) {
+	for _, e := range entries {
+		if e.ETag == etag {
+			return e, true
+		}
+	}
+	if len(entries) > 0 {
+		return entries[0], true
+	}
+
+	return lineage.Entry{}, false
+}
+
+// Dependencies initializes a new command instance for invocation
+func (r *RestoreSourceObjects) Dependencies(
+	c base.Container,
+) (err error) {
+	r.client, err = c.S3API()
+	if err != nil {
+		return err
+	}
+	r.db, err = c.DB()
+	r.logger = c.Logger()
+
+	return err
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"s3fc/boltdb"
+	"s3fc/logging"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+func TestRestoreSourceObjectsNotRestorable(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		key     string
+	}{
+		{name: "transforms", options: `"transforms": [{"op": "drop_empty"}]`, key: "a"},
+		{name: "strip bom", options: `"strip_bom": true`, key: "a"},
+		{name: "csv header", options: `"csv_header": true`, key: "a.csv"},
+		{name: "json mode", options: `"json_mode": "ndjson"`, key: "a.json"},
+		{name: "codec change", options: `"codec": "zstd"`, key: "a.gz"},
+		{name: "decoded source", options: `"delimiter_mode": "if_missing"`, key: "a.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, done := testDB(t)
+			defer done()
+
+			putJSON(t, db, `{
+				"bucket": "b",
+				"prefix": "p/",
+				"destination_bucket": "d",
+				"destination_path": "out",
+				"block_size": 8,
+				"delimiter": "\n",
+				"index": "ndjson",
+				`+tt.options+`
+			}`)
+			set := lookupObjectSet(t, db)
+
+			var destID []byte
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(set.Name())
+				dest := models.NewDestinationObject(*set)
+				dest.Key = aws.String("out/d")
+				dest.State = models.StateInSync
+
+				var err error
+				if destID, err = boltdb.AppendRow(b, dest); err != nil {
+					return err
+				}
+
+				row := models.NewSourceObject(*set)
+				row.Key = aws.String(set.Prefix + tt.key)
+				row.ETag = aws.String("etag")
+				row.Size = aws.Int64(4)
+				row.LastModified = aws.Time(time.Now())
+				row.State = models.StateInSync
+				row.DestinationObjectID = destID
+				_, err = boltdb.AppendRow(b, row)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// the S3 client is never used, restoring fails before reading
+			// the destination object's index
+			logger := logging.New()
+			logging.SetOutput(logger, ioutil.Discard)
+			r := RestoreSourceObjects{
+				Bucket: set.Bucket,
+				Prefix: set.Prefix,
+				ID:     base64.RawURLEncoding.EncodeToString(destID),
+				db:     db,
+				logger: logger,
+			}
+			if err := r.Invoke(context.Background()); !errors.Is(err, models.ErrNotRestorable) {
+				t.Errorf("Invoke() error = %v, want %v", err, models.ErrNotRestorable)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package commands
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"io"
 	"s3fc/base"
+	"s3fc/inventory"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -14,52 +17,154 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
+const (
+	// ShardingDelimiter shards a listing by the common prefixes found when
+	// listing with a "/" delimiter.
+	ShardingDelimiter = "delimiter"
+	// ShardingAlphabet shards a listing into key ranges split at the prefix
+	// followed by each character of an alphabet.
+	ShardingAlphabet = "alphabet"
+
+	defaultShardAlphabet    = "0123456789abcdefghijklmnopqrstuvwxyz"
+	defaultShardConcurrency = 8
+	shardBufferSize         = 1000
+)
+
+var (
+	// ErrInvalidSharding tells a caller that the requested sharding strategy
+	// is not supported
+	ErrInvalidSharding = errors.New("Invalid sharding, expected \"delimiter\" or \"alphabet\"")
+	// ErrChunkedPartFiles tells a caller that chunk_size and part_files can not
+	// be used together
+	ErrChunkedPartFiles = errors.New("Invalid request, chunk_size can not be combined with part_files")
+)
+
 // TakeInventory iterators over and s3 bucket prefix for object definitions and
 // stores them as line delimeted json objects in either another s3 object or a
-// file on disk
+// file on disk. When Versions is set the prefix is listed with
+// ListObjectVersions so that every version and delete marker is recorded.
+//
+// Large prefixes can be split into shards that are listed concurrently. The
+// shards are either merged into a single key sorted inventory, or, when
+// PartFiles is set, each written to their own part file with a Manifest of the
+// parts written to Destination.
+//
+// When ChunkSize is set the inventory is written as numbered chunks of at most
+// ChunkSize objects and the Manifest is rewritten after every chunk as a
+// checkpoint. With Resume set, an incomplete checkpoint left by an earlier
+// invocation is continued from its last key.
+//
+// Inventories are compressed when Destination ends in ".gz" or ".zst", and
+// Compact writes each object with short field names and without its Owner.
 type TakeInventory struct {
 	Bucket      string `json:"bucket"`
 	Prefix      string `json:"prefix"`
 	Destination string `json:"destination"`
+	Versions    bool   `json:"versions,omitempty"`
+
+	Sharding      string `json:"sharding,omitempty"`
+	ShardAlphabet string `json:"shard_alphabet,omitempty"`
+	Concurrency   int    `json:"concurrency,omitempty"`
+	PartFiles     bool   `json:"part_files,omitempty"`
+
+	ChunkSize int  `json:"chunk_size,omitempty"`
+	Resume    bool `json:"resume,omitempty"`
+
+	Compact bool `json:"compact,omitempty"`
 
 	logger    logrus.FieldLogger
 	client    s3iface.S3API
 	inventory base.InventoryManager
 }
 
+// inventoryShard is a contiguous range of keys under a prefix. Keys are listed
+// after startAfter and up to and including end, when they are set. Shards with
+// objects have already been listed.
+type inventoryShard struct {
+	prefix          string
+	delimiter       string
+	startAfter      string
+	versionIDMarker string
+	end             string
+	objects         []inventory.Object
+}
+
 // Invoke triggers the TakeInventory command
 func (t TakeInventory) Invoke(ctx context.Context) error {
-	var wg sync.WaitGroup
-	r, w := io.Pipe()
-
 	t.logger.WithFields(logrus.Fields{
 		"bucket":      t.Bucket,
 		"prefix":      t.Prefix,
 		"destination": t.Destination,
+		"versions":    t.Versions,
+		"sharding":    t.Sharding,
+		"part_files":  t.PartFiles,
 	}).Info("starting TakeInventory")
 
+	shards, err := t.shards(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.logger.WithField("shards", len(shards)).Debug("listing shards")
+
+	if t.ChunkSize > 0 {
+		if t.PartFiles {
+			return ErrChunkedPartFiles
+		}
+		return t.writeChunks(ctx, shards)
+	}
+
+	if t.PartFiles {
+		return t.writeParts(ctx, shards)
+	}
+
+	return t.writeInventory(ctx, t.Destination, func(
+		ctx context.Context,
+		f func(inventory.Object) bool,
+	) error {
+		return t.listShards(ctx, shards, f)
+	})
+}
+
+// Dependencies initializes a new command instance for invocation
+func (t *TakeInventory) Dependencies(
+	c base.Container,
+) (err error) {
+	t.client, err = c.S3API()
+	if err != nil {
+		return err
+	}
+	t.logger = c.Logger()
+	t.inventory = c.InventoryManager()
+
+	return nil
+}
+
+// writeInventory json encodes every object passed by list and writes them to
+// the destination through the InventoryManager
+func (t *TakeInventory) writeInventory(
+	ctx context.Context,
+	destination string,
+	list func(context.Context, func(inventory.Object) bool) error,
+) error {
+	var wg sync.WaitGroup
+	r, w := io.Pipe()
+
 	defer wg.Wait()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		enc := json.NewEncoder(w)
-		err := t.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
-			Bucket: aws.String(t.Bucket),
-			Prefix: aws.String(t.Prefix),
-		}, func(o *s3.ListObjectsV2Output, b bool) bool {
-			for _, ob := range o.Contents {
-				err := enc.Encode(ob)
-				if err != nil {
-					t.logger.
-						WithError(err).
-						Error("error while json encoding list output")
-					w.CloseWithError(err)
-					return false
-				}
+		enc := inventory.NewEncoder(w, t.Compact)
+		err := list(ctx, func(o inventory.Object) bool {
+			if err := enc.Encode(o); err != nil {
+				t.logger.
+					WithError(err).
+					Error("error while json encoding list output")
+				w.CloseWithError(err)
+				return false
 			}
-
-			return !b
+			return true
 		})
 
 		if err != nil {
@@ -75,19 +180,562 @@ func (t TakeInventory) Invoke(ctx context.Context) error {
 		w.CloseWithError(err)
 	}()
 
-	return t.inventory.WriteFrom(ctx, r, t.Destination)
+	return t.inventory.WriteFrom(ctx, r, destination)
 }
 
-// Dependencies initializes a new command instance for invocation
-func (t *TakeInventory) Dependencies(
-	c base.Container,
-) (err error) {
-	t.client, err = c.S3API()
+// writeParts concurrently writes each shard to its own part file and then
+// writes the manifest of the parts to the destination
+func (t *TakeInventory) writeParts(
+	ctx context.Context,
+	shards []inventoryShard,
+) error {
+	manifest := &inventory.Manifest{
+		Parts:    make([]string, len(shards)),
+		Complete: true,
+	}
+	for i := range shards {
+		manifest.Parts[i] = inventory.PartURL(t.Destination, i)
+	}
+
+	err := t.forEachShard(ctx, shards, func(
+		ctx context.Context,
+		i int,
+		s inventoryShard,
+	) error {
+		return t.writeInventory(ctx, manifest.Parts[i], func(
+			ctx context.Context,
+			f func(inventory.Object) bool,
+		) error {
+			return t.listShard(ctx, s, f)
+		})
+	})
 	if err != nil {
 		return err
 	}
-	t.logger = c.Logger()
-	t.inventory = c.InventoryManager()
+
+	return inventory.WriteManifest(ctx, t.inventory, t.Destination, manifest)
+}
+
+// writeChunks lists the shards in key order and writes them as chunks of at
+// most ChunkSize objects. The manifest is written after each chunk so that an
+// interrupted inventory can be resumed from the last chunk written.
+func (t *TakeInventory) writeChunks(
+	ctx context.Context,
+	shards []inventoryShard,
+) error {
+	manifest, err := t.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	if manifest.LastKey != "" {
+		t.logger.WithFields(logrus.Fields{
+			"chunks":          len(manifest.Parts),
+			"last_key":        manifest.LastKey,
+			"last_version_id": manifest.LastVersionID,
+		}).Info("resuming inventory from checkpoint")
+		shards = resumeShards(shards, manifest)
+	}
+
+	c := &chunkWriter{
+		ctx:      ctx,
+		t:        t,
+		manifest: manifest,
+	}
+	if err = t.listShards(ctx, shards, c.write); err != nil {
+		c.abort(err)
+		return err
+	}
+	if c.err != nil {
+		return c.err
+	}
+	if err = c.flush(); err != nil {
+		return err
+	}
+
+	manifest.Complete = true
+	return inventory.WriteManifest(ctx, t.inventory, t.Destination, manifest)
+}
+
+// checkpoint returns the manifest to continue writing chunks to. It is the
+// incomplete manifest found at the destination when resuming, otherwise an
+// empty one.
+func (t *TakeInventory) checkpoint(
+	ctx context.Context,
+) (*inventory.Manifest, error) {
+	if !t.Resume {
+		return new(inventory.Manifest), nil
+	}
+
+	manifest, err := inventory.ReadManifest(ctx, t.inventory, t.Destination)
+	if inventory.IsNotFound(err) {
+		return new(inventory.Manifest), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Complete {
+		t.logger.Info("checkpoint is complete, starting a new inventory")
+		return new(inventory.Manifest), nil
+	}
+
+	return manifest, nil
+}
+
+// resumeShards moves the start of every shard past the last key of a
+// checkpoint. Shards that end before the last key are dropped.
+func resumeShards(
+	shards []inventoryShard,
+	manifest *inventory.Manifest,
+) []inventoryShard {
+	lastKey := manifest.LastKey
+	output := make([]inventoryShard, 0, len(shards))
+	for _, s := range shards {
+		if s.objects != nil {
+			s.objects = resumeObjects(s.objects, manifest)
+			if len(s.objects) > 0 {
+				output = append(output, s)
+			}
+			continue
+		}
+
+		if s.end != "" && s.end < lastKey {
+			continue
+		}
+		if lastKey > s.startAfter {
+			s.startAfter = lastKey
+			if strings.HasPrefix(lastKey, s.prefix) {
+				s.versionIDMarker = manifest.LastVersionID
+			}
+		}
+		output = append(output, s)
+	}
+
+	return output
+}
+
+// resumeObjects drops the already listed objects that were written before the
+// checkpoint
+func resumeObjects(
+	objects []inventory.Object,
+	manifest *inventory.Manifest,
+) []inventory.Object {
+	for i, o := range objects {
+		key := aws.StringValue(o.Key)
+		if key < manifest.LastKey {
+			continue
+		}
+		if key > manifest.LastKey {
+			return objects[i:]
+		}
+		if manifest.LastVersionID == "" ||
+			aws.StringValue(o.VersionID) == manifest.LastVersionID {
+			return objects[i+1:]
+		}
+	}
 
 	return nil
 }
+
+// chunkWriter writes objects to numbered chunks and checkpoints the manifest
+// each time a chunk is completed
+type chunkWriter struct {
+	ctx      context.Context
+	t        *TakeInventory
+	manifest *inventory.Manifest
+
+	w    *io.PipeWriter
+	enc  *inventory.Encoder
+	done chan error
+	n    int
+	last inventory.Object
+	err  error
+}
+
+func (c *chunkWriter) write(o inventory.Object) bool {
+	if c.w == nil {
+		c.open()
+	}
+
+	if err := c.enc.Encode(o); err != nil {
+		c.t.logger.
+			WithError(err).
+			Error("error while json encoding list output")
+		c.abort(err)
+		c.err = err
+		return false
+	}
+	c.n++
+	c.last = o
+
+	if c.n < c.t.ChunkSize {
+		return true
+	}
+
+	if err := c.flush(); err != nil {
+		c.t.logger.
+			WithError(err).
+			Error("error while writing inventory chunk")
+		c.err = err
+		return false
+	}
+
+	return true
+}
+
+func (c *chunkWriter) open() {
+	r, w := io.Pipe()
+	url := inventory.PartURL(c.t.Destination, len(c.manifest.Parts))
+	done := make(chan error, 1)
+	go func() {
+		done <- c.t.inventory.WriteFrom(c.ctx, r, url)
+	}()
+
+	c.w, c.enc, c.done, c.n = w, inventory.NewEncoder(w, c.t.Compact), done, 0
+}
+
+// flush completes the current chunk, if any, and checkpoints the manifest
+func (c *chunkWriter) flush() error {
+	if c.w == nil {
+		return nil
+	}
+
+	c.w.Close()
+	err := <-c.done
+	c.w = nil
+	if err != nil {
+		return err
+	}
+
+	c.manifest.Parts = append(
+		c.manifest.Parts,
+		inventory.PartURL(c.t.Destination, len(c.manifest.Parts)),
+	)
+	c.manifest.LastKey = aws.StringValue(c.last.Key)
+	c.manifest.LastVersionID = aws.StringValue(c.last.VersionID)
+
+	return inventory.WriteManifest(c.ctx, c.t.inventory, c.t.Destination, c.manifest)
+}
+
+// abort discards the current chunk
+func (c *chunkWriter) abort(err error) {
+	if c.w == nil {
+		return
+	}
+
+	c.w.CloseWithError(err)
+	<-c.done
+	c.w = nil
+}
+
+// listShards concurrently lists shards and passes their objects to f in key
+// order. Shards are started in order so that the shard being passed to f has
+// always been started, later shards buffer up to shardBufferSize objects.
+func (t *TakeInventory) listShards(
+	parent context.Context,
+	shards []inventoryShard,
+	f func(inventory.Object) bool,
+) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	chs := make([]chan inventory.Object, len(shards))
+	for i := range chs {
+		chs[i] = make(chan inventory.Object, shardBufferSize)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := t.forEachShard(ctx, shards, func(
+			ctx context.Context,
+			i int,
+			s inventoryShard,
+		) error {
+			defer close(chs[i])
+			return t.listShard(ctx, s, func(o inventory.Object) bool {
+				select {
+				case <-ctx.Done():
+					return false
+				case chs[i] <- o:
+					return true
+				}
+			})
+		})
+		if err != nil {
+			cancel()
+		}
+		errCh <- err
+	}()
+
+	for _, ch := range chs {
+		for running := true; running; {
+			select {
+			case <-ctx.Done():
+				cancel()
+				if err := <-errCh; err != nil {
+					return err
+				}
+				return parent.Err()
+			case o, ok := <-ch:
+				if !ok {
+					running = false
+					continue
+				}
+				if !f(o) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return <-errCh
+}
+
+// forEachShard calls f for every shard, in order, with at most Concurrency
+// calls running at a time. The first error cancels the remaining calls.
+func (t *TakeInventory) forEachShard(
+	parent context.Context,
+	shards []inventoryShard,
+	f func(context.Context, int, inventoryShard) error,
+) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	concurrency := t.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultShardConcurrency
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+
+	for i, s := range shards {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func(i int, s inventoryShard) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if err := f(ctx, i, s); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}(i, s)
+			continue
+		}
+		break
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return parent.Err()
+}
+
+// shards splits the prefix into shards as per the configured sharding
+func (t *TakeInventory) shards(ctx context.Context) ([]inventoryShard, error) {
+	switch t.Sharding {
+	case "":
+		return []inventoryShard{{prefix: t.Prefix}}, nil
+	case ShardingDelimiter:
+		return t.delimiterShards(ctx)
+	case ShardingAlphabet:
+		return t.alphabetShards(), nil
+	}
+
+	return nil, ErrInvalidSharding
+}
+
+// delimiterShards lists the prefix with a "/" delimiter. Every common prefix
+// becomes a shard, the objects found directly under the prefix are kept as
+// already listed shards between them.
+func (t *TakeInventory) delimiterShards(
+	ctx context.Context,
+) ([]inventoryShard, error) {
+	var shards []inventoryShard
+	var objects []inventory.Object
+
+	flushObjects := func() {
+		if len(objects) > 0 {
+			shards = append(shards, inventoryShard{objects: objects})
+			objects = nil
+		}
+	}
+
+	err := t.listPages(ctx, inventoryShard{
+		prefix:    t.Prefix,
+		delimiter: "/",
+	}, func(page []inventory.Object, prefixes []string) bool {
+		for _, p := range prefixes {
+			for len(page) > 0 && aws.StringValue(page[0].Key) < p {
+				objects = append(objects, page[0])
+				page = page[1:]
+			}
+			flushObjects()
+			shards = append(shards, inventoryShard{prefix: p})
+		}
+		objects = append(objects, page...)
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	flushObjects()
+
+	return shards, nil
+}
+
+// alphabetShards splits the prefix into key ranges at the prefix followed by
+// each character of the shard alphabet. The ranges cover every key under the
+// prefix, even those with characters that are not in the alphabet.
+func (t *TakeInventory) alphabetShards() []inventoryShard {
+	alphabet := t.ShardAlphabet
+	if alphabet == "" {
+		alphabet = defaultShardAlphabet
+	}
+
+	bounds := make([]string, 0, len(alphabet))
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !seen[c] {
+			seen[c] = true
+			bounds = append(bounds, t.Prefix+string(c))
+		}
+	}
+	sort.Strings(bounds)
+
+	shards := make([]inventoryShard, 0, len(bounds)+1)
+	var startAfter string
+	for _, b := range bounds {
+		shards = append(shards, inventoryShard{
+			prefix:     t.Prefix,
+			startAfter: startAfter,
+			end:        b,
+		})
+		startAfter = b
+	}
+
+	return append(shards, inventoryShard{
+		prefix:     t.Prefix,
+		startAfter: startAfter,
+	})
+}
+
+// listShard passes every object of a shard to f until f returns false
+func (t *TakeInventory) listShard(
+	ctx context.Context,
+	s inventoryShard,
+	f func(inventory.Object) bool,
+) error {
+	if s.objects != nil {
+		for _, o := range s.objects {
+			if !f(o) {
+				break
+			}
+		}
+		return nil
+	}
+
+	return t.listPages(ctx, s, func(page []inventory.Object, _ []string) bool {
+		for _, o := range page {
+			if s.end != "" && aws.StringValue(o.Key) > s.end {
+				return false
+			}
+			if !f(o) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// listPages lists a shard page by page with either ListObjectsV2 or
+// ListObjectVersions. Each page's objects are passed to f in key order along
+// with the page's common prefixes.
+func (t *TakeInventory) listPages(
+	ctx context.Context,
+	s inventoryShard,
+	f func([]inventory.Object, []string) bool,
+) error {
+	var delimiter, startAfter, versionIDMarker *string
+	if s.delimiter != "" {
+		delimiter = aws.String(s.delimiter)
+	}
+	if s.startAfter != "" {
+		startAfter = aws.String(s.startAfter)
+	}
+	if s.versionIDMarker != "" {
+		versionIDMarker = aws.String(s.versionIDMarker)
+	}
+
+	if !t.Versions {
+		return t.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:     aws.String(t.Bucket),
+			Prefix:     aws.String(s.prefix),
+			Delimiter:  delimiter,
+			StartAfter: startAfter,
+		}, func(o *s3.ListObjectsV2Output, b bool) bool {
+			page := make([]inventory.Object, 0, len(o.Contents))
+			for _, ob := range o.Contents {
+				page = append(page, inventory.Object{Object: *ob})
+			}
+
+			return f(page, commonPrefixes(o.CommonPrefixes)) && !b
+		})
+	}
+
+	return t.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket:          aws.String(t.Bucket),
+		Prefix:          aws.String(s.prefix),
+		Delimiter:       delimiter,
+		KeyMarker:       startAfter,
+		VersionIdMarker: versionIDMarker,
+	}, func(o *s3.ListObjectVersionsOutput, b bool) bool {
+		page := make([]inventory.Object, 0, len(o.Versions)+len(o.DeleteMarkers))
+		for _, v := range o.Versions {
+			page = append(page, inventory.NewVersionObject(v))
+		}
+		for _, d := range o.DeleteMarkers {
+			page = append(page, inventory.NewDeleteMarkerObject(d))
+		}
+		sortVersions(page)
+
+		return f(page, commonPrefixes(o.CommonPrefixes)) && !b
+	})
+}
+
+// sortVersions orders versions by key and then from newest to oldest
+func sortVersions(page []inventory.Object) {
+	sort.SliceStable(page, func(i, j int) bool {
+		ki, kj := aws.StringValue(page[i].Key), aws.StringValue(page[j].Key)
+		if ki != kj {
+			return ki < kj
+		}
+		return aws.TimeValue(page[i].LastModified).After(
+			aws.TimeValue(page[j].LastModified),
+		)
+	})
+}
+
+func commonPrefixes(prefixes []*s3.CommonPrefix) []string {
+	output := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		output = append(output, aws.StringValue(p.Prefix))
+	}
+
+	return output
+}
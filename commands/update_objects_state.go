@@ -7,20 +7,29 @@ import (
 	"s3fc/base"
 	"s3fc/boltdb"
 	"s3fc/models"
+	"s3fc/planner"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/boltdb/bolt"
 )
 
 // UpdateObjectsState is a command that will set the passed State to the
 // provided list of bolt db ids in the passed ObjectSet by type ("destination"
-// or "source").
+// or "source"). A destination object that is IN_SYNC expires the destination
+// objects that its sources were moved from once they have been replaced.
+//
+// Written takes the outputs of WriteDestinationObject, their destination
+// objects are updated like the passed ids, the number of bytes written to them
+// and the time they were verified at are recorded, and the source objects
+// quarantined while writing them are flagged as QUARANTINED.
 type UpdateObjectsState struct {
-	Bucket string   `json:"bucket"`
-	Prefix string   `json:"prefix"`
-	Type   string   `json:"type"`
-	IDS    []string `json:"ids"`
-	State  string   `json:"state"`
+	Bucket  string                         `json:"bucket"`
+	Prefix  string                         `json:"prefix"`
+	Type    string                         `json:"type"`
+	IDS     []string                       `json:"ids"`
+	Written []WriteDestinationObjectOutput `json:"written,omitempty"`
+	State   string                         `json:"state"`
 
 	db *bolt.DB
 }
@@ -38,8 +47,16 @@ func (u UpdateObjectsState) Invoke(ctx context.Context) error {
 			return err
 		}
 
+		ids := u.IDS
+		for _, w := range u.Written {
+			if err = recordWritten(b, *set, w); err != nil {
+				return err
+			}
+			ids = append(ids, w.ID)
+		}
+
 		var current, row boltdb.Row
-		for _, idB64 := range u.IDS {
+		for _, idB64 := range ids {
 			current, row = nil, nil
 			id, err := base64.RawURLEncoding.DecodeString(idB64)
 			if err != nil {
@@ -79,11 +96,69 @@ func (u UpdateObjectsState) Invoke(ctx context.Context) error {
 			if err = boltdb.UpdateRow(b, id, row, current); err != nil {
 				return err
 			}
+
+			if u.Type == "destination" && state == models.StateInSync {
+				if err = planner.Expire(b, *set, id); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	})
 }
 
+// recordWritten records the number of bytes written to a destination object
+// and the time it was verified at, and flags the source objects quarantined
+// while writing it as QUARANTINED
+func recordWritten(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	w WriteDestinationObjectOutput,
+) error {
+	id, err := base64.RawURLEncoding.DecodeString(w.ID)
+	if err != nil {
+		return err
+	}
+
+	dest := models.NewDestinationObject(set)
+	if err = boltdb.LookupRow(b, id, dest); err != nil {
+		return err
+	}
+	written, err := dest.Copy()
+	if err != nil {
+		return err
+	}
+	written.WrittenSize = aws.Int64(w.WrittenSize)
+	if w.VerifiedAt != nil {
+		written.VerifiedAt = w.VerifiedAt
+	}
+	if err = boltdb.UpdateRow(b, id, written, dest); err != nil {
+		return err
+	}
+
+	for _, idB64 := range w.Quarantined {
+		id, err := base64.RawURLEncoding.DecodeString(idB64)
+		if err != nil {
+			return err
+		}
+
+		current := models.NewSourceObject(set)
+		if err = boltdb.LookupRow(b, id, current); err != nil {
+			return err
+		}
+		obj, err := current.Copy()
+		if err != nil {
+			return err
+		}
+		obj.State = models.StateQuarantined
+		if err = boltdb.UpdateRow(b, id, obj, current); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Dependencies initializes a new command instance for invocation
 func (u *UpdateObjectsState) Dependencies(
 	c base.Container,
@@ -3,18 +3,29 @@ package commands
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"io"
 	"s3fc/base"
 	"s3fc/boltdb"
 	"s3fc/models"
 	"s3fc/s3"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
 )
 
 // WriteDestinationObject queries for sources objects by their destination
 // object id and concatinates them as per partition and destination object
-// configuration.
+// configuration. It does not change the database, it reports the number of
+// bytes written, the time the destination object was verified at when the
+// object set deletes source objects, and the source objects quarantined while
+// merging CSV headers or JSON for UpdateObjectsState to record. A failed
+// verification fails the command, a destination object that can not be
+// verified, e.g. one encrypted with KMS, has no verified time and its source
+// objects are never deleted.
 type WriteDestinationObject struct {
 	Bucket string `json:"bucket"`
 	Prefix string `json:"prefix"`
@@ -22,23 +33,38 @@ type WriteDestinationObject struct {
 
 	client s3iface.S3API
 	db     *bolt.DB
+	logger logrus.FieldLogger
+	output WriteDestinationObjectOutput
+}
+
+// WriteDestinationObjectOutput the output of the command, passed on to
+// UpdateObjectsState as one of its written destination objects
+type WriteDestinationObjectOutput struct {
+	ID          string     `json:"id"`
+	WrittenSize int64      `json:"written_size"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	Quarantined []string   `json:"quarantined,omitempty"`
 }
 
 // Invoke triggers the WriteDestinationObject command
-func (w WriteDestinationObject) Invoke(ctx context.Context) error {
-	return w.db.View(func(tx *bolt.Tx) error {
-		set := models.NewObjectSet(w.Bucket, w.Prefix)
-		b, err := boltdb.LookupTable(tx, set)
-		if err != nil {
-			return err
-		}
+func (w *WriteDestinationObject) Invoke(ctx context.Context) error {
+	set := models.NewObjectSet(w.Bucket, w.Prefix)
+
+	id, err := base64.RawURLEncoding.DecodeString(w.ID)
+	if err != nil {
+		return err
+	}
 
-		id, err := base64.RawURLEncoding.DecodeString(w.ID)
+	var dest *models.DestinationObject
+	var sources []models.SourceObject
+	var sourceIDs [][]byte
+	if err = w.db.View(func(tx *bolt.Tx) error {
+		b, err := boltdb.LookupTable(tx, set)
 		if err != nil {
 			return err
 		}
 
-		dest := models.NewDestinationObject(*set)
+		dest = models.NewDestinationObject(*set)
 		if err = boltdb.LookupRow(b, id, dest); err != nil {
 			return err
 		}
@@ -47,7 +73,6 @@ func (w WriteDestinationObject) Invoke(ctx context.Context) error {
 		prefix := id
 		limit := 2048
 
-		sources := make([]models.SourceObject, 0, limit)
 		var exclusiveStart []byte
 		for running := true; running; {
 			ids, err := boltdb.PrefixQuery(
@@ -62,8 +87,12 @@ func (w WriteDestinationObject) Invoke(ctx context.Context) error {
 				if err = boltdb.LookupRow(b, sID, source); err != nil {
 					return err
 				}
+				if source.State == models.StateQuarantined {
+					continue
+				}
 
 				sources = append(sources, *source)
+				sourceIDs = append(sourceIDs, sID)
 			}
 
 			if len(ids) < limit {
@@ -71,12 +100,62 @@ func (w WriteDestinationObject) Invoke(ctx context.Context) error {
 				continue
 			}
 
-			exclusiveStart = ids[len(ids)-1]
+			exclusiveStart = boltdb.MakeIndex(prefix, ids[len(ids)-1])
 		}
 
-		_, err = s3.MergeObjects(ctx, w.client, *dest, sources)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	merged, err := s3.MergeObjects(ctx, w.client, *dest, sources)
+	if err != nil {
 		return err
-	})
+	}
+
+	if merged.Size != aws.Int64Value(dest.Size) {
+		w.logger.WithFields(logrus.Fields{
+			"key":          aws.StringValue(dest.Key),
+			"planned_size": aws.Int64Value(dest.Size),
+			"written_size": merged.Size,
+		}).Info("destination object size differs from its planned size")
+	}
+
+	w.output = WriteDestinationObjectOutput{
+		ID:          w.ID,
+		WrittenSize: merged.Size,
+	}
+	if dest.Parent.DeleteSources {
+		verified, err := s3.VerifyDestination(ctx, w.client, *dest, merged)
+		if err != nil {
+			return err
+		}
+		if verified {
+			w.output.VerifiedAt = aws.Time(time.Now())
+		} else {
+			w.logger.WithField("key", aws.StringValue(dest.Key)).Warn(
+				"destination object could not be verified, its sources are kept",
+			)
+		}
+	}
+	for _, q := range merged.Quarantined {
+		w.logger.WithFields(logrus.Fields{
+			"key":   aws.StringValue(sources[q.Index].Key),
+			"error": q.Err,
+		}).Warn("quarantined source object")
+
+		w.output.Quarantined = append(
+			w.output.Quarantined,
+			base64.RawURLEncoding.EncodeToString(sourceIDs[q.Index]),
+		)
+	}
+
+	return nil
+}
+
+// Report writes the written destination object as JSON
+func (w *WriteDestinationObject) Report(wr io.Writer) error {
+	return json.NewEncoder(wr).Encode(w.output)
 }
 
 // Dependencies initializes a new command instance for invocation
@@ -88,6 +167,7 @@ func (w *WriteDestinationObject) Dependencies(
 		return err
 	}
 	w.db, err = c.DB()
+	w.logger = c.Logger()
 
 	return err
 }
@@ -0,0 +1,242 @@
+// Package framing writes and reads destination objects of length-prefixed
+// records, so that binary source objects can be concatenated and split again.
+//
+// The length format writes every record as an 8 byte big-endian length,
+// followed by the record's bytes and, when checksums are enabled, the 4 byte
+// big-endian CRC-32C (Castagnoli) of the record's bytes.
+//
+// The tfrecord format is the one of TensorFlow's TFRecord files: an 8 byte
+// little-endian length, the 4 byte little-endian masked CRC-32C of the length,
+// the record's bytes, and the 4 byte little-endian masked CRC-32C of the
+// record's bytes.
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// DefaultMaxSize the largest record a Reader reads unless told otherwise
+const DefaultMaxSize = 256 << 20
+
+const (
+	// None source objects are not framed
+	None = ""
+	// Length records with a big-endian length prefix and an optional CRC
+	Length = "length"
+	// TFRecord records compatible with TensorFlow's TFRecord files
+	TFRecord = "tfrecord"
+)
+
+var (
+	// ErrInvalidFormat tells a caller that the framing format is not
+	// supported
+	ErrInvalidFormat = errors.New("Invalid framing, expected \"length\" or \"tfrecord\"")
+	// ErrChecksum tells a caller that a record does not match its checksum
+	ErrChecksum = errors.New("record checksum mismatch")
+	// ErrShortRecord tells a caller that a record has fewer bytes than its
+	// length
+	ErrShortRecord = errors.New("record is shorter than its length")
+	// ErrRecordTooLarge tells a caller that a record's length is larger than
+	// the reader's maximum record size
+	ErrRecordTooLarge = errors.New("record is larger than the maximum record size")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	exts = map[string]string{
+		Length:   ".records",
+		TFRecord: ".tfrecord",
+	}
+)
+
+// Validate checks that format is supported, None included
+func Validate(format string) error {
+	if _, ok := exts[format]; format != None && !ok {
+		return ErrInvalidFormat
+	}
+
+	return nil
+}
+
+// Ext the file extension of a framing format, e.g. ".tfrecord"
+func Ext(format string) string {
+	return exts[format]
+}
+
+// Overhead the number of bytes a record adds to its payload
+func Overhead(format string, checksum bool) int64 {
+	switch format {
+	case Length:
+		if checksum {
+			return 12
+		}
+		return 8
+	case TFRecord:
+		return 16
+	}
+
+	return 0
+}
+
+// Writer writes framed records
+type Writer struct {
+	w        io.Writer
+	format   string
+	checksum bool
+}
+
+// NewWriter returns a writer of records in format, checksum only applies to
+// the length format as TFRecord always has checksums
+func NewWriter(w io.Writer, format string, checksum bool) *Writer {
+	return &Writer{w: w, format: format, checksum: checksum || format == TFRecord}
+}
+
+// Copy writes a record of size bytes read from r
+func (w *Writer) Copy(r io.Reader, size int64) error {
+	if err := w.writeLength(size); err != nil {
+		return err
+	}
+
+	var crc hash.Hash32
+	dst := w.w
+	if w.checksum {
+		crc = crc32.New(castagnoli)
+		dst = io.MultiWriter(w.w, crc)
+	}
+
+	n, err := io.CopyN(dst, r, size)
+	if err == io.EOF || n < size {
+		return ErrShortRecord
+	}
+	if err != nil || crc == nil {
+		return err
+	}
+
+	return w.writeChecksum(crc.Sum32())
+}
+
+func (w *Writer) writeLength(size int64) error {
+	if w.format == TFRecord {
+		var b [12]byte
+		binary.LittleEndian.PutUint64(b[:8], uint64(size))
+		binary.LittleEndian.PutUint32(b[8:], mask(crc32.Checksum(b[:8], castagnoli)))
+		_, err := w.w.Write(b[:])
+		return err
+	}
+
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(size))
+	_, err := w.w.Write(b[:])
+	return err
+}
+
+func (w *Writer) writeChecksum(crc uint32) error {
+	var b [4]byte
+	if w.format == TFRecord {
+		binary.LittleEndian.PutUint32(b[:], mask(crc))
+	} else {
+		binary.BigEndian.PutUint32(b[:], crc)
+	}
+
+	_, err := w.w.Write(b[:])
+	return err
+}
+
+// Reader iterates the records of a destination object
+type Reader struct {
+	r        io.Reader
+	format   string
+	checksum bool
+	maxSize  uint64
+}
+
+// NewReader returns a reader of records in format, checksum must match the
+// writer's for the length format. Records larger than DefaultMaxSize fail with
+// ErrRecordTooLarge.
+func NewReader(r io.Reader, format string, checksum bool) *Reader {
+	return &Reader{
+		r:        r,
+		format:   format,
+		checksum: checksum || format == TFRecord,
+		maxSize:  DefaultMaxSize,
+	}
+}
+
+// SetMaxSize sets the largest record Next reads, e.g. to the size of the
+// destination object when it is known
+func (r *Reader) SetMaxSize(size int64) {
+	r.maxSize = uint64(size)
+}
+
+// Next returns the next record, io.EOF once there are no more records. The
+// length of the length format has no checksum, so it is checked against the
+// maximum record size before the record is read.
+func (r *Reader) Next() ([]byte, error) {
+	size, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if size > r.maxSize {
+		return nil, ErrRecordTooLarge
+	}
+
+	record := make([]byte, size)
+	if _, err = io.ReadFull(r.r, record); err != nil {
+		return nil, ErrShortRecord
+	}
+
+	if r.checksum {
+		var b [4]byte
+		if _, err = io.ReadFull(r.r, b[:]); err != nil {
+			return nil, ErrShortRecord
+		}
+
+		crc := crc32.Checksum(record, castagnoli)
+		if r.format == TFRecord {
+			if binary.LittleEndian.Uint32(b[:]) != mask(crc) {
+				return nil, ErrChecksum
+			}
+		} else if binary.BigEndian.Uint32(b[:]) != crc {
+			return nil, ErrChecksum
+		}
+	}
+
+	return record, nil
+}
+
+func (r *Reader) readLength() (uint64, error) {
+	if r.format == TFRecord {
+		var b [12]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return 0, eof(err)
+		}
+		if binary.LittleEndian.Uint32(b[8:]) != mask(crc32.Checksum(b[:8], castagnoli)) {
+			return 0, ErrChecksum
+		}
+		return binary.LittleEndian.Uint64(b[:8]), nil
+	}
+
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, eof(err)
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
+
+// eof keeps io.EOF at a record boundary and reports a record cut short
+// otherwise
+func eof(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return ErrShortRecord
+	}
+
+	return err
+}
+
+// mask the CRC masking of TFRecord files
+func mask(crc uint32) uint32 {
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
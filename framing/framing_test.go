@@ -0,0 +1,133 @@
+package framing
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := []string{"a", "", "bcd"}
+
+	tests := []struct {
+		name     string
+		format   string
+		checksum bool
+	}{
+		{name: "length", format: Length},
+		{name: "length with crc", format: Length, checksum: true},
+		{name: "tfrecord", format: TFRecord},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.format, tt.checksum)
+			var payload int64
+			for _, r := range records {
+				if err := w.Copy(strings.NewReader(r), int64(len(r))); err != nil {
+					t.Fatal(err)
+				}
+				payload += int64(len(r))
+			}
+
+			want := payload + int64(len(records))*Overhead(tt.format, tt.checksum)
+			if int64(buf.Len()) != want {
+				t.Errorf("wrote %d bytes, want %d", buf.Len(), want)
+			}
+
+			var got []string
+			r := NewReader(&buf, tt.format, tt.checksum)
+			for {
+				record, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(record))
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("Next() = %q, want %q", got, records)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		checksum bool
+		maxSize  int64
+		corrupt  func([]byte) []byte
+		wantErr  error
+	}{
+		{
+			name:    "truncated",
+			format:  Length,
+			corrupt: func(b []byte) []byte { return b[:len(b)-1] },
+			wantErr: ErrShortRecord,
+		},
+		{
+			name:    "truncated length",
+			format:  Length,
+			corrupt: func(b []byte) []byte { return b[:4] },
+			wantErr: ErrShortRecord,
+		},
+		{
+			name:     "checksum",
+			format:   Length,
+			checksum: true,
+			corrupt:  func(b []byte) []byte { b[8] ^= 0xff; return b },
+			wantErr:  ErrChecksum,
+		},
+		{
+			name:    "corrupt length",
+			format:  Length,
+			corrupt: func(b []byte) []byte { b[0] = 0xff; return b },
+			wantErr: ErrRecordTooLarge,
+		},
+		{
+			name:    "record larger than max size",
+			format:  Length,
+			maxSize: 2,
+			corrupt: func(b []byte) []byte { return b },
+			wantErr: ErrRecordTooLarge,
+		},
+		{
+			name:    "tfrecord length checksum",
+			format:  TFRecord,
+			corrupt: func(b []byte) []byte { b[0] ^= 0xff; return b },
+			wantErr: ErrChecksum,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.format, tt.checksum)
+			if err := w.Copy(strings.NewReader("abc"), 3); err != nil {
+				t.Fatal(err)
+			}
+
+			r := NewReader(bytes.NewReader(tt.corrupt(buf.Bytes())), tt.format, tt.checksum)
+			if tt.maxSize > 0 {
+				r.SetMaxSize(tt.maxSize)
+			}
+			if _, err := r.Next(); err != tt.wantErr {
+				t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopyShortRecord(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, Length, false)
+	if err := w.Copy(strings.NewReader("ab"), 3); err != ErrShortRecord {
+		t.Errorf("Copy() error = %v, want %v", err, ErrShortRecord)
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/aws/aws-xray-sdk-go v1.0.0-rc.14
 	github.com/boltdb/bolt v1.3.1
 	github.com/google/uuid v1.1.1
+	github.com/klauspost/compress v1.10.3
 	github.com/pkg/errors v0.9.0 // indirect
 	github.com/sirupsen/logrus v1.4.2
 )
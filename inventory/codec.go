@@ -0,0 +1,32 @@
+package inventory
+
+import (
+	"io"
+	"s3fc/codec"
+)
+
+// compress returns a reader of the compressed contents of r, the codec is
+// picked from the extension of the inventory being written. Errors
+// compressing or reading the returned reader are passed back to r.
+func compress(r *io.PipeReader, destination string) *io.PipeReader {
+	c := codec.FromExt(destination)
+	if c == codec.None {
+		return r
+	}
+
+	cr, cw := io.Pipe()
+	go func() {
+		zw, err := codec.NewWriter(c, cw)
+		if err == nil {
+			_, err = io.Copy(zw, r)
+			if closeErr := zw.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		r.CloseWithError(err)
+		cw.CloseWithError(err)
+	}()
+
+	return cr
+}
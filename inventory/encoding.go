@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// compactObject is the compact projection of an Object. It uses short field
+// names, records LastModified in unix milliseconds, and drops the Owner.
+type compactObject struct {
+	K *string `json:"k,omitempty"`
+	E *string `json:"e,omitempty"`
+	S *int64  `json:"s,omitempty"`
+	M *int64  `json:"m,omitempty"`
+	C *string `json:"c,omitempty"`
+	V *string `json:"v,omitempty"`
+	L *bool   `json:"l,omitempty"`
+	D *bool   `json:"d,omitempty"`
+}
+
+// record decodes either format of an inventory line
+type record struct {
+	Object
+	compactObject
+}
+
+// Encoder writes Objects as line delimited json in either the full or the
+// compact format
+type Encoder struct {
+	enc     *json.Encoder
+	compact bool
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer, compact bool) *Encoder {
+	return &Encoder{
+		enc:     json.NewEncoder(w),
+		compact: compact,
+	}
+}
+
+// Encode writes an Object as a single line
+func (e *Encoder) Encode(o Object) error {
+	if !e.compact {
+		return e.enc.Encode(o)
+	}
+
+	c := compactObject{
+		K: o.Key,
+		E: o.ETag,
+		S: o.Size,
+		C: o.StorageClass,
+		V: o.VersionID,
+		L: o.IsLatest,
+		D: o.IsDeleteMarker,
+	}
+	if o.LastModified != nil {
+		c.M = aws.Int64(aws.TimeValue(o.LastModified).UnixNano() / int64(time.Millisecond))
+	}
+
+	return e.enc.Encode(c)
+}
+
+// Decoder reads Objects from line delimited json written in either the full
+// or the compact format
+type Decoder struct {
+	dec *json.Decoder
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		dec: json.NewDecoder(r),
+	}
+}
+
+// Decode reads the next Object
+func (d *Decoder) Decode(o *Object) error {
+	var rec record
+	if err := d.dec.Decode(&rec); err != nil {
+		return err
+	}
+
+	if rec.K == nil {
+		*o = rec.Object
+		return nil
+	}
+
+	*o = Object{
+		Object: s3.Object{
+			Key:          rec.K,
+			ETag:         rec.E,
+			Size:         rec.S,
+			StorageClass: rec.C,
+		},
+		VersionID:      rec.V,
+		IsLatest:       rec.L,
+		IsDeleteMarker: rec.D,
+	}
+	if rec.M != nil {
+		o.LastModified = aws.Time(time.Unix(0, *rec.M*int64(time.Millisecond)).UTC())
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package inventory
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestEncoding(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	object := Object{
+		Object: s3.Object{
+			Key:          aws.String("p/a.csv"),
+			ETag:         aws.String(`"etag"`),
+			Size:         aws.Int64(10),
+			LastModified: aws.Time(modified),
+			StorageClass: aws.String("STANDARD"),
+		},
+	}
+	version := object
+	version.VersionID = aws.String("v1")
+	version.IsLatest = aws.Bool(false)
+	marker := NewDeleteMarkerObject(&s3.DeleteMarkerEntry{
+		Key:          aws.String("p/b.csv"),
+		LastModified: aws.Time(modified),
+		VersionId:    aws.String("v2"),
+		IsLatest:     aws.Bool(true),
+	})
+
+	tests := []struct {
+		name   string
+		object Object
+	}{
+		{name: "object", object: object},
+		{name: "version", object: version},
+		{name: "delete marker", object: marker},
+	}
+
+	for _, compact := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if compact {
+				name += " compact"
+			}
+			t.Run(name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := NewEncoder(&buf, compact).Encode(tt.object); err != nil {
+					t.Fatal(err)
+				}
+				if compact && bytes.Contains(buf.Bytes(), []byte(`"Key"`)) {
+					t.Errorf("compact encoding = %s", buf.Bytes())
+				}
+
+				dec := NewDecoder(&buf)
+				var got Object
+				if err := dec.Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.object) {
+					t.Errorf("Decode() = %v, want %v", got, tt.object)
+				}
+				if err := dec.Decode(&got); err != io.EOF {
+					t.Errorf("Decode() error = %v, want EOF", err)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeMixed(t *testing.T) {
+	input := `{"Key":"a","Size":1}
+{"k":"b","s":2,"m":1577836800000}
+`
+	want := []Object{
+		{Object: s3.Object{Key: aws.String("a"), Size: aws.Int64(1)}},
+		{Object: s3.Object{
+			Key:          aws.String("b"),
+			Size:         aws.Int64(2),
+			LastModified: aws.Time(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+		}},
+	}
+
+	dec := NewDecoder(bytes.NewBufferString(input))
+	for _, w := range want {
+		var got Object
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("Decode() = %v, want %v", got, w)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"s3fc/codec"
 	"s3fc/s3"
 	"strings"
 
@@ -30,6 +31,8 @@ func New(
 	}
 }
 
+// WriteFrom writes an inventory to a file:// or s3:// destination. Inventories
+// written to urls ending in ".gz" or ".zst" are compressed with gzip or zstd.
 func (i *InventoryManager) WriteFrom(
 	ctx context.Context,
 	r *io.PipeReader,
@@ -49,8 +52,10 @@ func (i *InventoryManager) WriteFrom(
 
 	switch destinationURL.Scheme {
 	case "file":
+		r = compress(r, destinationURL.Path)
 		return fileDestination(destinationURL, r)
 	case "s3":
+		r = compress(r, destinationURL.Path)
 		return s3Destination(ctx, i.client, destinationURL, r)
 	}
 
@@ -58,6 +63,8 @@ func (i *InventoryManager) WriteFrom(
 	return fmt.Errorf("Invalid Destination: %s", destination)
 }
 
+// ReadTo reads an inventory from a file:// or s3:// source. Compressed
+// inventories are detected by their header and decompressed.
 func (i *InventoryManager) ReadTo(
 	ctx context.Context,
 	w *io.PipeWriter,
@@ -73,14 +80,28 @@ func (i *InventoryManager) ReadTo(
 		return err
 	}
 
+	var body io.ReadCloser
 	switch sourceURL.Scheme {
 	case "file":
-		return fileSource(sourceURL, w)
+		body, err = fileSource(sourceURL)
 	case "s3":
-		return s3Source(ctx, i.client, sourceURL, w)
+		body, err = s3Source(ctx, i.client, sourceURL)
 	default:
 		return fmt.Errorf("Invalid Source: %s", sourceURL.Scheme)
 	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	r, err := codec.Decompress(body)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
 }
 
 func fileDestination(
@@ -112,23 +133,15 @@ func s3Destination(
 	return err
 }
 
-func fileSource(url *url.URL, w *io.PipeWriter) error {
-	file, err := os.Open(url.Path)
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(w, file)
-	file.Close()
-	return err
+func fileSource(url *url.URL) (io.ReadCloser, error) {
+	return os.Open(url.Path)
 }
 
 func s3Source(
 	ctx context.Context,
 	client s3iface.S3API,
 	url *url.URL,
-	w *io.PipeWriter,
-) error {
+) (io.ReadCloser, error) {
 	bucket := url.Hostname()
 	key := strings.Trim(url.EscapedPath(), "/")
 
@@ -137,9 +150,8 @@ func s3Source(
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = io.Copy(w, output.Body)
-	output.Body.Close()
-	return err
+
+	return output.Body, nil
 }
@@ -0,0 +1,106 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"s3fc/base"
+	"s3fc/s3"
+	"strings"
+)
+
+// Manifest describes an inventory that has been written as several part
+// files. It is stored at the inventory's url in place of the inventory itself
+// and lists the url of every part in key order.
+//
+// Chunked inventories rewrite their manifest after every chunk as a checkpoint
+// of the last key written, an incomplete manifest can be resumed from there.
+type Manifest struct {
+	Parts         []string `json:"parts"`
+	Complete      bool     `json:"complete"`
+	LastKey       string   `json:"last_key,omitempty"`
+	LastVersionID string   `json:"last_version_id,omitempty"`
+}
+
+// PartURL returns the url of the n-th part of an inventory. The part number is
+// inserted before the file extension so that "inventory.json" becomes
+// "inventory.00001.json".
+func PartURL(rawURL string, n int) string {
+	dir, file := path.Split(rawURL)
+	name, ext := file, ""
+	if i := strings.Index(file, "."); i > 0 {
+		name, ext = file[:i], file[i:]
+	}
+
+	return fmt.Sprintf("%s%s.%05d%s", dir, name, n, ext)
+}
+
+// IsNotFound checks if an error is for a nonexistent inventory file or object
+func IsNotFound(err error) bool {
+	return os.IsNotExist(err) || s3.IsNotFound(err)
+}
+
+// WriteManifest writes a manifest to the provided url
+func WriteManifest(
+	ctx context.Context,
+	m base.InventoryManager,
+	url string,
+	manifest *Manifest,
+) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(json.NewEncoder(w).Encode(manifest))
+	}()
+
+	return m.WriteFrom(ctx, r, url)
+}
+
+// ReadManifest reads a manifest from the provided url. Manifests written
+// before inventories were checkpointed have no complete field and are always
+// complete.
+func ReadManifest(
+	ctx context.Context,
+	m base.InventoryManager,
+	url string,
+) (*Manifest, error) {
+	r, w := io.Pipe()
+	go m.ReadTo(ctx, w, url)
+
+	manifest := Manifest{Complete: true}
+	err := json.NewDecoder(r).Decode(&manifest)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// ReadParts reads every part listed in a manifest, in order, to the provided
+// writer as if they were a single inventory.
+func ReadParts(
+	ctx context.Context,
+	m base.InventoryManager,
+	w *io.PipeWriter,
+	manifest *Manifest,
+) (err error) {
+	defer func() {
+		w.CloseWithError(err)
+	}()
+
+	for _, part := range manifest.Parts {
+		r, pw := io.Pipe()
+		go m.ReadTo(ctx, pw, part)
+
+		_, err = io.Copy(w, r)
+		r.CloseWithError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// memoryManager an InventoryManager keeping inventories in memory
+type memoryManager map[string][]byte
+
+func (m memoryManager) WriteFrom(
+	ctx context.Context,
+	r *io.PipeReader,
+	destination string,
+) error {
+	b, err := ioutil.ReadAll(r)
+	m[destination] = b
+	return err
+}
+
+func (m memoryManager) ReadTo(
+	ctx context.Context,
+	w *io.PipeWriter,
+	source string,
+) error {
+	b, ok := m[source]
+	if !ok {
+		w.CloseWithError(os.ErrNotExist)
+		return os.ErrNotExist
+	}
+	_, err := io.Copy(w, bytes.NewReader(b))
+	w.CloseWithError(err)
+	return err
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     Manifest
+	}{
+		{
+			name:     "before checkpoints",
+			manifest: `{"parts":["a.00001.json"]}`,
+			want:     Manifest{Parts: []string{"a.00001.json"}, Complete: true},
+		},
+		{
+			name:     "checkpoint",
+			manifest: `{"parts":["a.00001.json"],"complete":false,"last_key":"k"}`,
+			want:     Manifest{Parts: []string{"a.00001.json"}, LastKey: "k"},
+		},
+		{
+			name:     "complete",
+			manifest: `{"parts":["a.00001.json"],"complete":true}`,
+			want:     Manifest{Parts: []string{"a.00001.json"}, Complete: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := memoryManager{"a.json": []byte(tt.manifest)}
+			got, err := ReadManifest(context.Background(), m, "a.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ReadManifest() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := memoryManager{}
+	want := &Manifest{
+		Parts:         []string{PartURL("a.json", 1), PartURL("a.json", 2)},
+		LastKey:       "k",
+		LastVersionID: "v",
+	}
+	if err := WriteManifest(context.Background(), m, "a.json", want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadManifest(context.Background(), m, "a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadManifest() = %+v, want %+v", got, want)
+	}
+	if want.Parts[1] != "a.00002.json" {
+		t.Errorf("PartURL() = %s, want a.00002.json", want.Parts[1])
+	}
+}
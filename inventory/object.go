@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Object is a single line of an inventory. Listings done with ListObjectsV2
+// only populate the embedded s3.Object, listings done with ListObjectVersions
+// also record the version of the object and whether it is a delete marker.
+type Object struct {
+	s3.Object
+	VersionID      *string `json:"VersionId,omitempty"`
+	IsLatest       *bool   `json:",omitempty"`
+	IsDeleteMarker *bool   `json:",omitempty"`
+}
+
+// NewVersionObject maps an s3.ObjectVersion to an inventory Object
+func NewVersionObject(v *s3.ObjectVersion) Object {
+	return Object{
+		Object: s3.Object{
+			ETag:         v.ETag,
+			Key:          v.Key,
+			LastModified: v.LastModified,
+			Owner:        v.Owner,
+			Size:         v.Size,
+			StorageClass: v.StorageClass,
+		},
+		VersionID: v.VersionId,
+		IsLatest:  v.IsLatest,
+	}
+}
+
+// NewDeleteMarkerObject maps an s3.DeleteMarkerEntry to an inventory Object
+func NewDeleteMarkerObject(d *s3.DeleteMarkerEntry) Object {
+	return Object{
+		Object: s3.Object{
+			Key:          d.Key,
+			LastModified: d.LastModified,
+			Owner:        d.Owner,
+		},
+		VersionID:      d.VersionId,
+		IsLatest:       d.IsLatest,
+		IsDeleteMarker: aws.Bool(true),
+	}
+}
+
+// IsCurrent reports whether the object is the current version of its key.
+// Objects from unversioned listings are always current.
+func (o Object) IsCurrent() bool {
+	return o.IsLatest == nil || aws.BoolValue(o.IsLatest)
+}
+
+// IsDeleted reports whether the object is a delete marker
+func (o Object) IsDeleted() bool {
+	return aws.BoolValue(o.IsDeleteMarker)
+}
@@ -0,0 +1,165 @@
+// Package lineage writes and reads the sidecar index of a destination object,
+// the byte range every source object takes in it.
+//
+// The ndjson format is one JSON object per source object with its "key",
+// "etag", "offset" and "length".
+//
+// The binary format starts with the 8 bytes "S3FCIDX1", followed by every
+// source object as the uvarints of its offset and length, and its key and
+// ETag each as a uvarint length followed by their bytes.
+package lineage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+const (
+	// None no sidecar index is written
+	None = ""
+	// NDJSON one JSON object per line
+	NDJSON = "ndjson"
+	// Binary a compact binary encoding
+	Binary = "binary"
+)
+
+var (
+	// ErrInvalidFormat tells a caller that the index format is not supported
+	ErrInvalidFormat = errors.New("Invalid index, expected \"ndjson\" or \"binary\"")
+	// ErrInvalidIndex tells a caller that a binary index is malformed
+	ErrInvalidIndex = errors.New("Invalid binary index")
+
+	magic = []byte("S3FCIDX1")
+	// maxString is well above the longest S3 key, 1024 bytes
+	maxString uint64 = 4096
+
+	exts = map[string]string{
+		NDJSON: ".index.ndjson",
+		Binary: ".index.bin",
+	}
+	contentTypes = map[string]string{
+		NDJSON: "application/x-ndjson",
+		Binary: "application/octet-stream",
+	}
+)
+
+// Entry the byte range a source object takes in its destination object
+type Entry struct {
+	Key    string `json:"key"`
+	ETag   string `json:"etag"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// Validate checks that format is supported, None included
+func Validate(format string) error {
+	if _, ok := exts[format]; format != None && !ok {
+		return ErrInvalidFormat
+	}
+
+	return nil
+}
+
+// Ext the suffix added to a destination object's key for its index, e.g.
+// ".index.ndjson"
+func Ext(format string) string {
+	return exts[format]
+}
+
+// ContentType the content type of an index in format
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Encode writes the entries of an index in format
+func Encode(w io.Writer, format string, entries []Entry) error {
+	if format == NDJSON {
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(magic)
+	var b [binary.MaxVarintLen64]byte
+	uvarint := func(v uint64) {
+		bw.Write(b[:binary.PutUvarint(b[:], v)])
+	}
+	for _, e := range entries {
+		uvarint(uint64(e.Offset))
+		uvarint(uint64(e.Length))
+		uvarint(uint64(len(e.Key)))
+		bw.WriteString(e.Key)
+		uvarint(uint64(len(e.ETag)))
+		bw.WriteString(e.ETag)
+	}
+
+	return bw.Flush()
+}
+
+// Decode reads the entries of an index in format
+func Decode(r io.Reader, format string) ([]Entry, error) {
+	var entries []Entry
+	if format == NDJSON {
+		dec := json.NewDecoder(r)
+		for {
+			var e Entry
+			if err := dec.Decode(&e); err == io.EOF {
+				return entries, nil
+			} else if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != string(magic) {
+		return nil, ErrInvalidIndex
+	}
+	for {
+		offset, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+
+		var e Entry
+		e.Offset = int64(offset)
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		e.Length = int64(length)
+		if e.Key, err = readString(br); err != nil {
+			return nil, err
+		}
+		if e.ETag, err = readString(br); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func readString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > maxString {
+		return "", ErrInvalidIndex
+	}
+
+	b := make([]byte, n)
+	if _, err = io.ReadFull(br, b); err != nil {
+		return "", ErrInvalidIndex
+	}
+	return string(b), nil
+}
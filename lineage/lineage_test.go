@@ -0,0 +1,77 @@
+package lineage
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Key: "p/a.csv", ETag: `"etag-a"`, Offset: 0, Length: 10},
+		{Key: "p/b.csv", ETag: `"etag-b"`, Offset: 10, Length: 300},
+		{Key: "", ETag: "", Offset: 310, Length: 0},
+	}
+
+	for _, format := range []string{NDJSON, Binary} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, format, entries); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Decode(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, entries) {
+				t.Errorf("Decode() = %v, want %v", got, entries)
+			}
+		})
+	}
+}
+
+func TestDecodeBinaryErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, Binary, []Entry{{Key: "a", ETag: "b", Length: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "no magic", input: []byte("S3FCIDX0")},
+		{name: "truncated", input: valid[:len(valid)-1]},
+		{name: "long string", input: append(append([]byte{}, magic...), 0, 1, 0xff, 0xff, 0x01)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(bytes.NewReader(tt.input), Binary); err == nil {
+				t.Error("Decode() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr error
+	}{
+		{format: None},
+		{format: NDJSON},
+		{format: Binary},
+		{format: "csv", wantErr: ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if err := Validate(tt.format); err != tt.wantErr {
+				t.Errorf("Validate(%q) = %v, want %v", tt.format, err, tt.wantErr)
+			}
+		})
+	}
+}
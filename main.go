@@ -50,21 +50,31 @@ type S3CatEvent struct {
 	AssumeRole string  `json:"assume_role"`
 	ExternalID *string `json:"external_id"`
 
-	LoadInventory          *commands.LoadInventory          `json:"load_inventory,omitempty"`
-	PlanNewObjects         *commands.PlanNewObjects         `json:"plan_new_objects,omitempty"`
-	PutObjectSet           *commands.PutObjectSet           `json:"put_object_set,omitempty"`
-	TakeInventory          *commands.TakeInventory          `json:"take_inventory,omitempty"`
-	UpdateObjectsState     *commands.UpdateObjectsState     `json:"update_object_state,omitempty"`
-	WriteDestinationObject *commands.WriteDestinationObject `json:"write_destination_object,omitempty"`
-
-	ListObjectByState *queries.ListObjectByState `json:"list_objects_by_state,omitempty"`
-	GetSourceStats    *queries.GetSourceStats    `json:"get_source_stats,omitempty"`
+	CompactDestinationObjects *commands.CompactDestinationObjects `json:"compact_destination_objects,omitempty"`
+	DeleteSourceObjects       *commands.DeleteSourceObjects       `json:"delete_source_objects,omitempty"`
+	LoadInventory             *commands.LoadInventory             `json:"load_inventory,omitempty"`
+	PlanNewObjects            *commands.PlanNewObjects            `json:"plan_new_objects,omitempty"`
+	PutObjectSet              *commands.PutObjectSet              `json:"put_object_set,omitempty"`
+	RestoreSourceObjects      *commands.RestoreSourceObjects      `json:"restore_source_objects,omitempty"`
+	TakeInventory             *commands.TakeInventory             `json:"take_inventory,omitempty"`
+	UpdateObjectsState        *commands.UpdateObjectsState        `json:"update_object_state,omitempty"`
+	WriteDestinationObject    *commands.WriteDestinationObject    `json:"write_destination_object,omitempty"`
+
+	ListObjectByState      *queries.ListObjectByState      `json:"list_objects_by_state,omitempty"`
+	GetSourceStats         *queries.GetSourceStats         `json:"get_source_stats,omitempty"`
+	PreviewPlan            *queries.PreviewPlan            `json:"preview_plan,omitempty"`
+	ListDestinationEntries *queries.ListDestinationEntries `json:"list_destination_entries,omitempty"`
 }
 
 // S3CatOutput is the output of responses.
 type S3CatOutput struct {
-	ListObjectByStateOutput *queries.ListObjectByStateOutput `json:"list_objects_by_state,omitempty"`
-	GetSourceStatsOutput    *queries.GetSourceStatsOutput    `json:"get_source_stats,omitempty"`
+	ListObjectByStateOutput      *queries.ListObjectByStateOutput      `json:"list_objects_by_state,omitempty"`
+	GetSourceStatsOutput         *queries.GetSourceStatsOutput         `json:"get_source_stats,omitempty"`
+	PreviewPlanOutput            *queries.PreviewPlanOutput            `json:"preview_plan,omitempty"`
+	ListDestinationEntriesOutput *queries.ListDestinationEntriesOutput `json:"list_destination_entries,omitempty"`
+
+	PlanNewObjectsOutput         *commands.PlanNewObjectsOutput         `json:"plan_new_objects,omitempty"`
+	WriteDestinationObjectOutput *commands.WriteDestinationObjectOutput `json:"write_destination_object,omitempty"`
 }
 
 // S3CatOutputHandler takes requests, routes to command or query and return a
@@ -85,18 +95,28 @@ func (s *S3CatOutputHandler) HandleRequest(ctx context.Context, event S3CatEvent
 	logger := logging.NewEventLogger(ctx, log)
 
 	switch {
+	case event.CompactDestinationObjects != nil:
+		action = event.CompactDestinationObjects
+	case event.DeleteSourceObjects != nil:
+		action = event.DeleteSourceObjects
 	case event.LoadInventory != nil:
 		action = event.LoadInventory
 	case event.PlanNewObjects != nil:
 		action = event.PlanNewObjects
+		output.PlanNewObjectsOutput = new(commands.PlanNewObjectsOutput)
+		queryOutput = output.PlanNewObjectsOutput
 	case event.PutObjectSet != nil:
 		action = event.PutObjectSet
+	case event.RestoreSourceObjects != nil:
+		action = event.RestoreSourceObjects
 	case event.TakeInventory != nil:
 		action = event.TakeInventory
 	case event.UpdateObjectsState != nil:
 		action = event.UpdateObjectsState
 	case event.WriteDestinationObject != nil:
 		action = event.WriteDestinationObject
+		output.WriteDestinationObjectOutput = new(commands.WriteDestinationObjectOutput)
+		queryOutput = output.WriteDestinationObjectOutput
 	case event.ListObjectByState != nil:
 		action = event.ListObjectByState
 		output.ListObjectByStateOutput = new(queries.ListObjectByStateOutput)
@@ -105,6 +125,14 @@ func (s *S3CatOutputHandler) HandleRequest(ctx context.Context, event S3CatEvent
 		action = event.GetSourceStats
 		output.GetSourceStatsOutput = new(queries.GetSourceStatsOutput)
 		queryOutput = output.GetSourceStatsOutput
+	case event.PreviewPlan != nil:
+		action = event.PreviewPlan
+		output.PreviewPlanOutput = new(queries.PreviewPlanOutput)
+		queryOutput = output.PreviewPlanOutput
+	case event.ListDestinationEntries != nil:
+		action = event.ListDestinationEntries
+		output.ListDestinationEntriesOutput = new(queries.ListDestinationEntriesOutput)
+		queryOutput = output.ListDestinationEntriesOutput
 	default:
 		logger.WithError(errInvalidRequest).Errorf("error parsing request")
 		return nil, errInvalidRequest
@@ -142,6 +170,19 @@ func (s *S3CatOutputHandler) HandleRequest(ctx context.Context, event S3CatEvent
 			logger.WithError(err).Errorf("error invoking command")
 			return nil, err
 		}
+
+		if r, ok := v.(base.Reporter); ok {
+			var buf bytes.Buffer
+			if err := r.Report(&buf); err != nil {
+				logger.WithError(err).Errorf("error reporting command result")
+				return nil, err
+			}
+
+			if err := json.Unmarshal(buf.Bytes(), queryOutput); err != nil {
+				logger.WithError(err).Errorf("error decoding command result")
+				return nil, err
+			}
+		}
 	case base.Query:
 		logger.Info("running query")
 		var buf bytes.Buffer
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"s3fc/codec"
+	"s3fc/framing"
+	"strings"
+)
+
+const (
+	// ArchiveTar writes destination objects as tar files, compressed when the
+	// object set has a codec
+	ArchiveTar = "tar"
+	// ArchiveZip writes destination objects as zip files
+	ArchiveZip = "zip"
+)
+
+var (
+	// ErrInvalidArchive tells a caller that the archive format is not
+	// supported
+	ErrInvalidArchive = errors.New("Invalid archive, expected \"tar\" or \"zip\"")
+	// ErrArchiveCodec tells a caller that zip archives can not be compressed
+	// with a codec
+	ErrArchiveCodec = errors.New("zip archives can not be combined with a codec")
+	// ErrArchiveMode tells a caller that archives can not be combined with
+	// options that rewrite the content of source objects
+	ErrArchiveMode = errors.New("archive can not be combined with gzip_members, csv_header or json_mode")
+)
+
+// ValidateArchive checks the object set's archive configuration
+func (o *ObjectSet) ValidateArchive() error {
+	switch o.Archive {
+	case "":
+		return nil
+	case ArchiveTar, ArchiveZip:
+	default:
+		return ErrInvalidArchive
+	}
+
+	if o.Archive == ArchiveZip && o.Codec != codec.None {
+		return ErrArchiveCodec
+	}
+	if o.GzipMembers || o.CSVHeader || o.JSONMode != "" {
+		return ErrArchiveMode
+	}
+
+	return nil
+}
+
+// ArchiveExt the extension of the object set's archive format
+func (o *ObjectSet) ArchiveExt() string {
+	if o.Archive == "" {
+		return ""
+	}
+
+	return "." + o.Archive
+}
+
+// formatExt the extension of the object set's archive or framing format
+func (o *ObjectSet) formatExt() string {
+	return o.ArchiveExt() + framing.Ext(o.Framing)
+}
+
+// ArchiveContentType the content type of the object set's archive format
+func (o *ObjectSet) ArchiveContentType() string {
+	switch o.Archive {
+	case ArchiveTar:
+		return "application/x-tar"
+	case ArchiveZip:
+		return "application/zip"
+	}
+
+	return ""
+}
+
+// EntryName is the name of a source object's archive entry, its key relative
+// to the object set's prefix
+func (o *ObjectSet) EntryName(key string) string {
+	if name := strings.TrimPrefix(key, o.Prefix); name != "" {
+		return name
+	}
+
+	return key
+}
+
+// ArchiveEntrySize is an upper bound of the bytes a source object adds to an
+// archive. A tar entry has a header block, a PAX header for names that do not
+// fit the header block, and is padded to the block size. A zip entry has a
+// local and a central directory header that both hold its name, a data
+// descriptor, and is deflated, which at worst adds a stored block header for
+// every 16 KiB.
+func (o *ObjectSet) ArchiveEntrySize(key string, size int64) int64 {
+	name := int64(len(o.EntryName(key)))
+
+	switch o.Archive {
+	case ArchiveTar:
+		entry := tarBlock + padTar(size)
+		if name > 100 {
+			entry += tarBlock + padTar(name+64)
+		}
+		return entry
+	case ArchiveZip:
+		return size + 5*(size/16384+1) + 2*name + zipEntryOverhead
+	}
+
+	return size
+}
+
+// archiveTrailerSize is the number of bytes that end an archive, the two zero
+// blocks of a tar file or the (zip64) end of central directory records of a
+// zip file
+func (o *ObjectSet) archiveTrailerSize() int64 {
+	switch o.Archive {
+	case ArchiveTar:
+		return 2 * tarBlock
+	case ArchiveZip:
+		return 98
+	}
+
+	return 0
+}
+
+const (
+	tarBlock = 512
+	// zipEntryOverhead the fixed size of the local header, central directory
+	// header, extended timestamp and zip64 extra fields, and data descriptor
+	zipEntryOverhead = 30 + 46 + 2*9 + 28 + 24
+)
+
+func padTar(size int64) int64 {
+	return (size + tarBlock - 1) / tarBlock * tarBlock
+}
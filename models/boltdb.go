@@ -2,7 +2,9 @@ package models
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"math"
 	"s3fc/boltdb"
 	"time"
 
@@ -36,16 +38,19 @@ var (
 	sourceObjectSchema = updateMap(
 		boltdb.Schema(
 			"destination_object",
+			"previous_destination_object",
 			"is_source_object",
+			"version_id",
 		),
 		objectSchema,
 	)
 
 	sourceObjectIndexes = updateMap(
 		map[string][]byte{
-			"destination_object": []byte("idx_destination"),
-			"state":              []byte("idx_source_state"),
-			"key":                []byte("idx_source_key"),
+			"destination_object":          []byte("idx_destination"),
+			"previous_destination_object": []byte("idx_previous_destination"),
+			"state":                       []byte("idx_source_state"),
+			"key":                         []byte("idx_source_key"),
 		},
 		objectIndexes,
 	)
@@ -53,6 +58,9 @@ var (
 	destinationObjectSchema = updateMap(
 		boltdb.Schema(
 			"is_destination_object",
+			"partition",
+			"verified_at",
+			"written_size",
 		),
 		objectSchema,
 	)
@@ -69,6 +77,35 @@ var (
 		"destination_bucket",
 		"destination_path",
 		"delimiter",
+		"delimiter_mode",
+		"delimiter_placement",
+		"header",
+		"footer",
+		"strip_bom",
+		"gzip_members",
+		"codec",
+		"block_size_target",
+		"compression_ratio",
+		"csv_header",
+		"header_mismatch",
+		"json_mode",
+		"archive",
+		"framing",
+		"frame_crc",
+		"index",
+		"transforms",
+		"delete_sources",
+		"retention",
+		"filter",
+		"partitioning",
+		"key_template",
+		"deterministic_keys",
+		"packing",
+		"sort_by",
+		"max_sources",
+		"min_block_size",
+		"max_age",
+		"top_up",
 	)
 
 	objectSetColumns = mergeValues(
@@ -119,18 +156,211 @@ func (o *ObjectSet) Unmarshal(values map[string][]byte) error {
 	if v, ok := values["delimiter"]; ok {
 		o.Delimiter = v
 	}
+	if v, ok := values["delimiter_mode"]; ok {
+		o.DelimiterMode = string(v)
+	}
+	if v, ok := values["delimiter_placement"]; ok {
+		o.DelimiterPlacement = string(v)
+	}
+	if v, ok := values["header"]; ok {
+		o.Header = v
+	}
+	if v, ok := values["footer"]; ok {
+		o.Footer = v
+	}
+	if v, ok := values["strip_bom"]; ok {
+		o.StripBOM = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["gzip_members"]; ok {
+		o.GzipMembers = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["codec"]; ok {
+		o.Codec = string(v)
+	}
+	if v, ok := values["csv_header"]; ok {
+		o.CSVHeader = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["header_mismatch"]; ok {
+		o.HeaderMismatch = string(v)
+	}
+	if v, ok := values["json_mode"]; ok {
+		o.JSONMode = string(v)
+	}
+	if v, ok := values["archive"]; ok {
+		o.Archive = string(v)
+	}
+	if v, ok := values["framing"]; ok {
+		o.Framing = string(v)
+	}
+	if v, ok := values["frame_crc"]; ok {
+		o.FrameCRC = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["index"]; ok {
+		o.Index = string(v)
+	}
+	if v, ok := values["delete_sources"]; ok {
+		o.DeleteSources = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["retention"]; ok && v != nil {
+		o.Retention = time.Duration(boltdb.Ltoi(v))
+	}
+	if v, ok := values["block_size_target"]; ok {
+		o.BlockSizeTarget = string(v)
+	}
+	if v, ok := values["compression_ratio"]; ok && v != nil {
+		o.CompressionRatio = math.Float64frombits(uint64(boltdb.Ltoi(v)))
+	}
+	o.gzipDelimiter = nil
+	o.EncodedDelimiter()
+	o.Filter = nil
+	if v, ok := values["filter"]; ok && len(v) > 0 {
+		o.Filter = new(KeyFilter)
+		if err := json.Unmarshal(v, o.Filter); err != nil {
+			return err
+		}
+		if err := o.Filter.Compile(); err != nil {
+			return err
+		}
+	}
+	if v, ok := values["packing"]; ok {
+		o.Packing = string(v)
+	}
+	if v, ok := values["sort_by"]; ok {
+		o.SortBy = string(v)
+	}
+	if v, ok := values["max_sources"]; ok && v != nil {
+		o.MaxSources = boltdb.Ltoi(v)
+	}
+	if v, ok := values["min_block_size"]; ok && v != nil {
+		o.MinBlockSize = boltdb.Ltoi(v)
+	}
+	if v, ok := values["max_age"]; ok && v != nil {
+		o.MaxAge = time.Duration(boltdb.Ltoi(v))
+	}
+	if v, ok := values["key_template"]; ok {
+		o.KeyTemplate = string(v)
+	}
+	if err := o.CompileKeyTemplate(); err != nil {
+		return err
+	}
+	if v, ok := values["deterministic_keys"]; ok {
+		o.DeterministicKeys = bytes.Equal(v, valueTrue)
+	}
+	if v, ok := values["top_up"]; ok {
+		o.TopUp = bytes.Equal(v, valueTrue)
+	}
+	o.Transforms = nil
+	if v, ok := values["transforms"]; ok && len(v) > 0 {
+		if err := json.Unmarshal(v, &o.Transforms); err != nil {
+			return err
+		}
+		if err := o.Transforms.Compile(); err != nil {
+			return err
+		}
+	}
+	o.Partitioning = nil
+	if v, ok := values["partitioning"]; ok && len(v) > 0 {
+		o.Partitioning = new(Partitioning)
+		if err := json.Unmarshal(v, o.Partitioning); err != nil {
+			return err
+		}
+		if err := o.Partitioning.Compile(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // Marshal maps values from of an object set to a bolt database
 func (o *ObjectSet) Marshal() (map[string][]byte, error) {
-	return map[string][]byte{
-		"block_size":         boltdb.Itol(o.BlockSize),
-		"destination_bucket": []byte(o.DestinationBucket),
-		"destination_path":   []byte(o.DestinationPath),
-		"delimiter":          o.Delimiter,
-	}, nil
+	values := map[string][]byte{
+		"block_size":          boltdb.Itol(o.BlockSize),
+		"destination_bucket":  []byte(o.DestinationBucket),
+		"destination_path":    []byte(o.DestinationPath),
+		"delimiter":           o.Delimiter,
+		"delimiter_mode":      []byte(o.DelimiterMode),
+		"delimiter_placement": []byte(o.DelimiterPlacement),
+		"header":              o.Header,
+		"footer":              o.Footer,
+		"strip_bom":           valueFalse,
+		"gzip_members":        valueFalse,
+		"codec":               []byte(o.Codec),
+		"block_size_target":   []byte(o.BlockSizeTarget),
+		"csv_header":          valueFalse,
+		"header_mismatch":     []byte(o.HeaderMismatch),
+		"json_mode":           []byte(o.JSONMode),
+		"archive":             []byte(o.Archive),
+		"framing":             []byte(o.Framing),
+		"frame_crc":           valueFalse,
+		"index":               []byte(o.Index),
+		"delete_sources":      valueFalse,
+		"retention":           boltdb.Itol(int64(o.Retention)),
+		"compression_ratio":   boltdb.Itol(int64(math.Float64bits(o.CompressionRatio))),
+		"transforms":          nil,
+		"filter":              nil,
+		"partitioning":        nil,
+		"key_template":        nil,
+		"deterministic_keys":  valueFalse,
+		"top_up":              valueFalse,
+		"packing":             []byte(o.Packing),
+		"sort_by":             []byte(o.SortBy),
+		"max_sources":         boltdb.Itol(o.MaxSources),
+		"min_block_size":      boltdb.Itol(o.MinBlockSize),
+		"max_age":             boltdb.Itol(int64(o.MaxAge)),
+	}
+
+	if o.KeyTemplate != "" {
+		values["key_template"] = []byte(o.KeyTemplate)
+	}
+
+	if o.DeterministicKeys {
+		values["deterministic_keys"] = valueTrue
+	}
+	if o.StripBOM {
+		values["strip_bom"] = valueTrue
+	}
+	if o.GzipMembers {
+		values["gzip_members"] = valueTrue
+	}
+	if o.CSVHeader {
+		values["csv_header"] = valueTrue
+	}
+	if o.FrameCRC {
+		values["frame_crc"] = valueTrue
+	}
+	if o.DeleteSources {
+		values["delete_sources"] = valueTrue
+	}
+	if o.TopUp {
+		values["top_up"] = valueTrue
+	}
+
+	if o.Filter != nil && !o.Filter.IsEmpty() {
+		v, err := json.Marshal(o.Filter)
+		if err != nil {
+			return nil, err
+		}
+		values["filter"] = v
+	}
+
+	if len(o.Transforms) > 0 {
+		v, err := json.Marshal(o.Transforms)
+		if err != nil {
+			return nil, err
+		}
+		values["transforms"] = v
+	}
+
+	if o.Partitioning != nil && !o.Partitioning.IsEmpty() {
+		v, err := json.Marshal(o.Partitioning)
+		if err != nil {
+			return nil, err
+		}
+		values["partitioning"] = v
+	}
+
+	return values, nil
 }
 
 // ObjectPrototype prototype function for instantiating an Object as a
@@ -311,10 +541,20 @@ func (s *SourceObject) Unmarshal(values map[string][]byte) error {
 		s.Key = nil
 	}
 
+	if v, ok := values["version_id"]; ok && v != nil {
+		s.VersionID = aws.String(string(v))
+	} else {
+		s.VersionID = nil
+	}
+
 	if v, ok := values["destination_object"]; ok {
 		s.DestinationObjectID = v
 	}
 
+	if v, ok := values["previous_destination_object"]; ok {
+		s.PreviousDestinationObjectID = v
+	}
+
 	if v, ok := values["is_source_object"]; !ok || !bytes.Equal(v, valueTrue) {
 		return ErrNotDestinationObject
 	}
@@ -333,7 +573,13 @@ func (s *SourceObject) Marshal() (map[string][]byte, error) {
 		_, values["key"] = s.PK()
 	}
 
+	values["version_id"] = nil
+	if s.VersionID != nil {
+		values["version_id"] = []byte(aws.StringValue(s.VersionID))
+	}
+
 	values["destination_object"] = s.DestinationObjectID
+	values["previous_destination_object"] = s.PreviousDestinationObjectID
 	values["is_source_object"] = valueTrue
 
 	return values, nil
@@ -392,6 +638,24 @@ func (d *DestinationObject) Unmarshal(values map[string][]byte) error {
 		d.Key = nil
 	}
 
+	if v, ok := values["partition"]; ok && v != nil {
+		d.Partition = string(v)
+	} else {
+		d.Partition = ""
+	}
+
+	if v, ok := values["verified_at"]; ok && v != nil {
+		d.VerifiedAt = aws.Time(time.Unix(0, boltdb.Ltoi(v)))
+	} else {
+		d.VerifiedAt = nil
+	}
+
+	if v, ok := values["written_size"]; ok && v != nil {
+		d.WrittenSize = aws.Int64(boltdb.Ltoi(v))
+	} else {
+		d.WrittenSize = nil
+	}
+
 	if v, ok := values["is_destination_object"]; !ok || !bytes.Equal(v, valueTrue) {
 		return ErrNotDestinationObject
 	}
@@ -410,6 +674,21 @@ func (d *DestinationObject) Marshal() (map[string][]byte, error) {
 		_, values["key"] = d.PK()
 	}
 
+	values["partition"] = nil
+	if d.Partition != "" {
+		values["partition"] = []byte(d.Partition)
+	}
+
+	values["verified_at"] = nil
+	if d.VerifiedAt != nil {
+		values["verified_at"] = boltdb.Itol(d.VerifiedAt.UnixNano())
+	}
+
+	values["written_size"] = nil
+	if d.WrittenSize != nil {
+		values["written_size"] = boltdb.Itol(*d.WrittenSize)
+	}
+
 	values["is_destination_object"] = valueTrue
 	return values, nil
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObjectSetUnmarshal(t *testing.T) {
+	stored := NewObjectSet("b", "p/")
+	stored.BlockSize = 8
+	stored.Delimiter = []byte("\n")
+	stored.Retention = time.Hour
+
+	tests := []struct {
+		name      string
+		missing   []string
+		retention time.Duration
+	}{
+		{name: "round trip", retention: time.Hour},
+		// object sets stored before a key was added read it as nil
+		{name: "without retention", missing: []string{"retention"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := stored.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range tt.missing {
+				values[k] = nil
+			}
+
+			set := NewObjectSet("b", "p/")
+			if err = set.Unmarshal(values); err != nil {
+				t.Fatal(err)
+			}
+			if set.BlockSize != 8 || set.Retention != tt.retention {
+				t.Errorf(
+					"Unmarshal() block size = %d, retention = %v, want 8, %v",
+					set.BlockSize, set.Retention, tt.retention,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"path"
+	"s3fc/codec"
+	"strings"
+)
+
+const (
+	// BlockSizeStored compares the block size to the size of source objects
+	// as they are stored
+	BlockSizeStored = "stored"
+	// BlockSizeCompressed compares the block size to the estimated size of
+	// destination objects compressed with the object set's codec
+	BlockSizeCompressed = "compressed"
+	// BlockSizeUncompressed compares the block size to the estimated size of
+	// the decompressed source objects
+	BlockSizeUncompressed = "uncompressed"
+)
+
+var (
+	// ErrInvalidBlockSizeTarget tells a caller that what the block size
+	// targets is not supported
+	ErrInvalidBlockSizeTarget = errors.New("Invalid block_size_target, expected \"stored\", \"compressed\" or \"uncompressed\"")
+	// ErrInvalidCompressionRatio tells a caller that the compression ratio is
+	// below 1
+	ErrInvalidCompressionRatio = errors.New("Invalid compression_ratio, expected a value of at least 1")
+	// ErrGzipMembersCodec tells a caller that gzip members can not be written
+	// with another codec
+	ErrGzipMembersCodec = errors.New("gzip_members can only be combined with the gzip codec")
+)
+
+// OutputCodec the codec destination objects are compressed with
+func (o *ObjectSet) OutputCodec() string {
+	if o.Codec == codec.None && o.GzipMembers {
+		return codec.Gzip
+	}
+
+	return o.Codec
+}
+
+// ValidateCodec checks the object set's compression configuration
+func (o *ObjectSet) ValidateCodec() error {
+	if err := codec.Validate(o.Codec); err != nil {
+		return err
+	}
+	if o.GzipMembers && o.Codec != codec.None && o.Codec != codec.Gzip {
+		return ErrGzipMembersCodec
+	}
+
+	switch o.BlockSizeTarget {
+	case "", BlockSizeStored, BlockSizeCompressed, BlockSizeUncompressed:
+	default:
+		return ErrInvalidBlockSizeTarget
+	}
+	if o.CompressionRatio != 0 && o.CompressionRatio < 1 {
+		return ErrInvalidCompressionRatio
+	}
+
+	return nil
+}
+
+// TargetSize estimates the bytes a source object adds to its destination
+// object as per what the block size targets. The codec of a source object is
+// picked from its key's extension and CompressionRatio is the expected ratio
+// of uncompressed to compressed bytes.
+func (o *ObjectSet) TargetSize(key string, size int64) int64 {
+	ratio := o.CompressionRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	compressed := codec.FromExt(key) != codec.None
+
+	switch o.BlockSizeTarget {
+	case BlockSizeCompressed:
+		if !compressed && o.OutputCodec() != codec.None {
+			return int64(float64(size) / ratio)
+		}
+	case BlockSizeUncompressed:
+		if compressed {
+			return int64(float64(size) * ratio)
+		}
+	}
+
+	return size
+}
+
+// DestinationExt replaces the compression extension of a source object's
+// extension with the one of the object set's output codec, e.g. ".csv.gz"
+// becomes ".csv.zst". Archives and framed records get the extensions of their
+// format and codec, e.g. ".tar.gz".
+func (o *ObjectSet) DestinationExt(ext string) string {
+	c := o.OutputCodec()
+	if format := o.formatExt(); format != "" {
+		return format + codec.Ext(c)
+	}
+	if c == codec.None {
+		return ext
+	}
+
+	if compression := path.Ext(ext); compressedExts[compression] {
+		ext = strings.TrimSuffix(ext, compression)
+	}
+
+	return ext + codec.Ext(c)
+}
@@ -0,0 +1,36 @@
+package models
+
+import "errors"
+
+const (
+	// HeaderMismatchReject fails writing a destination object when the header
+	// of one of its CSV source objects does not match the first one, the
+	// default
+	HeaderMismatchReject = "reject"
+	// HeaderMismatchQuarantine leaves source objects whose CSV header does not
+	// match out of their destination object and flags them as QUARANTINED
+	HeaderMismatchQuarantine = "quarantine"
+)
+
+var (
+	// ErrInvalidHeaderMismatch tells a caller that the handling of mismatched
+	// CSV headers is not supported
+	ErrInvalidHeaderMismatch = errors.New("Invalid header_mismatch, expected \"reject\" or \"quarantine\"")
+	// ErrHeaderMismatchWithoutCSV tells a caller that header_mismatch requires
+	// csv_header
+	ErrHeaderMismatchWithoutCSV = errors.New("header_mismatch requires csv_header")
+)
+
+// ValidateCSV checks the object set's CSV configuration
+func (o *ObjectSet) ValidateCSV() error {
+	switch o.HeaderMismatch {
+	case "", HeaderMismatchReject, HeaderMismatchQuarantine:
+	default:
+		return ErrInvalidHeaderMismatch
+	}
+	if o.HeaderMismatch != "" && !o.CSVHeader {
+		return ErrHeaderMismatchWithoutCSV
+	}
+
+	return nil
+}
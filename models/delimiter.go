@@ -0,0 +1,63 @@
+package models
+
+import "errors"
+
+const (
+	// DelimiterAlways writes the delimiter after every source object
+	DelimiterAlways = "always"
+	// DelimiterIfMissing writes the delimiter after a source object only when
+	// it does not already end with it
+	DelimiterIfMissing = "if_missing"
+
+	// DelimiterAfterEach places the delimiter after every source object,
+	// including the last one
+	DelimiterAfterEach = "after_each"
+	// DelimiterBetween places the delimiter between source objects only
+	DelimiterBetween = "between"
+)
+
+var (
+	// ErrInvalidDelimiterMode tells a caller that the delimiter mode is not
+	// supported
+	ErrInvalidDelimiterMode = errors.New("Invalid delimiter_mode, expected \"always\" or \"if_missing\"")
+	// ErrInvalidDelimiterPlacement tells a caller that the delimiter placement
+	// is not supported
+	ErrInvalidDelimiterPlacement = errors.New("Invalid delimiter_placement, expected \"after_each\" or \"between\"")
+)
+
+// ValidateDelimiter checks the object set's delimiter configuration
+func (o *ObjectSet) ValidateDelimiter() error {
+	switch o.DelimiterMode {
+	case "", DelimiterAlways, DelimiterIfMissing:
+	default:
+		return ErrInvalidDelimiterMode
+	}
+
+	switch o.DelimiterPlacement {
+	case "", DelimiterAfterEach, DelimiterBetween:
+	default:
+		return ErrInvalidDelimiterPlacement
+	}
+
+	return nil
+}
+
+// FrameSize is the number of bytes the header and footer, or the archive
+// trailer, add to every destination object. Framed records have neither.
+func (o *ObjectSet) FrameSize() int64 {
+	if o.Archive != "" {
+		return o.archiveTrailerSize()
+	}
+	if o.Framing != "" {
+		return 0
+	}
+
+	return int64(len(o.Header) + len(o.Footer))
+}
+
+// DecodesSources reports whether source objects are decompressed before they
+// are written to a destination object, instead of being copied as they are
+// when they are compressed with the output codec
+func (o *ObjectSet) DecodesSources() bool {
+	return o.DelimiterMode == DelimiterIfMissing || o.StripBOM || len(o.Transforms) > 0
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// KeyFilter limits which objects under an ObjectSet's prefix are source
+// objects. Globs and regular expressions are matched against the key relative
+// to the prefix, globs without a "/" are also matched against the key's base
+// name. When any include pattern is set a key must match at least one of them,
+// and it must not match any exclude pattern.
+type KeyFilter struct {
+	Include         []string   `json:"include,omitempty"`
+	Exclude         []string   `json:"exclude,omitempty"`
+	IncludeRegex    []string   `json:"include_regex,omitempty"`
+	ExcludeRegex    []string   `json:"exclude_regex,omitempty"`
+	MinSize         *int64     `json:"min_size,omitempty"`
+	MaxSize         *int64     `json:"max_size,omitempty"`
+	MinLastModified *time.Time `json:"min_last_modified,omitempty"`
+	MaxLastModified *time.Time `json:"max_last_modified,omitempty"`
+
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+}
+
+// IsEmpty reports whether the filter has no conditions
+func (k *KeyFilter) IsEmpty() bool {
+	return len(k.Include) == 0 &&
+		len(k.Exclude) == 0 &&
+		len(k.IncludeRegex) == 0 &&
+		len(k.ExcludeRegex) == 0 &&
+		k.MinSize == nil &&
+		k.MaxSize == nil &&
+		k.MinLastModified == nil &&
+		k.MaxLastModified == nil
+}
+
+// Compile validates the filter's globs and compiles its regular expressions.
+// It must be called before Match.
+func (k *KeyFilter) Compile() (err error) {
+	for _, p := range append(append([]string{}, k.Include...), k.Exclude...) {
+		if _, err = path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+
+	if k.includeRegex, err = compileAll(k.IncludeRegex); err != nil {
+		return err
+	}
+	k.excludeRegex, err = compileAll(k.ExcludeRegex)
+	return err
+}
+
+// Match reports whether an object with the passed key, relative to the object
+// set's prefix, passes the filter
+func (k *KeyFilter) Match(key string, o s3.Object) bool {
+	if len(k.Include) > 0 || len(k.includeRegex) > 0 {
+		if !matchGlobs(k.Include, key) && !matchRegexps(k.includeRegex, key) {
+			return false
+		}
+	}
+
+	if matchGlobs(k.Exclude, key) || matchRegexps(k.excludeRegex, key) {
+		return false
+	}
+
+	size := aws.Int64Value(o.Size)
+	if k.MinSize != nil && size < *k.MinSize {
+		return false
+	}
+	if k.MaxSize != nil && size > *k.MaxSize {
+		return false
+	}
+
+	lastModified := aws.TimeValue(o.LastModified)
+	if k.MinLastModified != nil && lastModified.Before(*k.MinLastModified) {
+		return false
+	}
+	if k.MaxLastModified != nil && lastModified.After(*k.MaxLastModified) {
+		return false
+	}
+
+	return true
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	output := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, re)
+	}
+
+	return output, nil
+}
+
+func matchGlobs(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(key)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func matchRegexps(patterns []*regexp.Regexp, key string) bool {
+	for _, re := range patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestKeyFilterMatch(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	object := s3.Object{Size: aws.Int64(10), LastModified: aws.Time(modified)}
+
+	tests := []struct {
+		name   string
+		filter KeyFilter
+		key    string
+		want   bool
+	}{
+		{name: "empty", key: "a/b.json", want: true},
+		{
+			name:   "include glob on base name",
+			filter: KeyFilter{Include: []string{"*.json"}},
+			key:    "a/b.json",
+			want:   true,
+		},
+		{
+			name:   "include glob with path",
+			filter: KeyFilter{Include: []string{"b/*.json"}},
+			key:    "a/b.json",
+			want:   false,
+		},
+		{
+			name:   "exclude wins over include",
+			filter: KeyFilter{Include: []string{"*.json"}, Exclude: []string{"a/*"}},
+			key:    "a/b.json",
+			want:   false,
+		},
+		{
+			name:   "include regex",
+			filter: KeyFilter{IncludeRegex: []string{`^a/`}},
+			key:    "a/b.json",
+			want:   true,
+		},
+		{
+			name:   "include glob or regex",
+			filter: KeyFilter{Include: []string{"*.csv"}, IncludeRegex: []string{`\.json$`}},
+			key:    "a/b.json",
+			want:   true,
+		},
+		{
+			name:   "exclude regex",
+			filter: KeyFilter{ExcludeRegex: []string{`\.tmp$`}},
+			key:    "a/b.tmp",
+			want:   false,
+		},
+		{
+			name:   "min size",
+			filter: KeyFilter{MinSize: aws.Int64(11)},
+			key:    "a",
+			want:   false,
+		},
+		{
+			name:   "max size",
+			filter: KeyFilter{MaxSize: aws.Int64(10)},
+			key:    "a",
+			want:   true,
+		},
+		{
+			name:   "min last modified",
+			filter: KeyFilter{MinLastModified: aws.Time(modified.Add(time.Hour))},
+			key:    "a",
+			want:   false,
+		},
+		{
+			name:   "max last modified",
+			filter: KeyFilter{MaxLastModified: aws.Time(modified.Add(-time.Hour))},
+			key:    "a",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.filter.Match(tt.key, object); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyFilterCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  KeyFilter
+		wantErr bool
+	}{
+		{name: "valid", filter: KeyFilter{Include: []string{"*.json"}, IncludeRegex: []string{`^a`}}},
+		{name: "bad glob", filter: KeyFilter{Exclude: []string{"["}}, wantErr: true},
+		{name: "bad regex", filter: KeyFilter{ExcludeRegex: []string{"("}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Compile(); (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
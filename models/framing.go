@@ -0,0 +1,28 @@
+package models
+
+import (
+	"errors"
+	"s3fc/framing"
+)
+
+var (
+	// ErrFramingMode tells a caller that framed records can not be combined
+	// with options that rewrite or wrap the content of source objects
+	ErrFramingMode = errors.New("framing can not be combined with gzip_members, csv_header, json_mode or archive")
+)
+
+// ValidateFraming checks the object set's record framing configuration
+func (o *ObjectSet) ValidateFraming() error {
+	if err := framing.Validate(o.Framing); err != nil {
+		return err
+	}
+	if o.Framing == framing.None {
+		return nil
+	}
+
+	if o.GzipMembers || o.CSVHeader || o.JSONMode != "" || o.Archive != "" {
+		return ErrFramingMode
+	}
+
+	return nil
+}
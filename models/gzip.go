@@ -0,0 +1,30 @@
+package models
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// EncodedDelimiter is the delimiter as it is written between source objects.
+// When the object set concatenates gzip members the delimiter is wrapped in
+// its own gzip member, an empty delimiter is not written at all.
+func (o *ObjectSet) EncodedDelimiter() []byte {
+	if !o.GzipMembers || len(o.Delimiter) == 0 {
+		return o.Delimiter
+	}
+	if o.gzipDelimiter == nil {
+		o.gzipDelimiter = GzipMember(o.Delimiter)
+	}
+
+	return o.gzipDelimiter
+}
+
+// GzipMember compresses data into a single gzip member
+func GzipMember(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+
+	return buf.Bytes()
+}
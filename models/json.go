@@ -0,0 +1,35 @@
+package models
+
+import "errors"
+
+const (
+	// JSONArray merges source objects into a single top-level JSON array,
+	// the elements of source objects that are arrays are added to it one by
+	// one and any other JSON value is added as a single element
+	JSONArray = "array"
+	// JSONLines validates source objects as JSON Lines and normalizes them to
+	// one compact JSON value per line
+	JSONLines = "ndjson"
+)
+
+var (
+	// ErrInvalidJSONMode tells a caller that the JSON mode is not supported
+	ErrInvalidJSONMode = errors.New("Invalid json_mode, expected \"array\" or \"ndjson\"")
+	// ErrCSVAndJSON tells a caller that an object set can not be both CSV and
+	// JSON
+	ErrCSVAndJSON = errors.New("csv_header and json_mode can not be combined")
+)
+
+// ValidateJSON checks the object set's JSON configuration
+func (o *ObjectSet) ValidateJSON() error {
+	switch o.JSONMode {
+	case "", JSONArray, JSONLines:
+	default:
+		return ErrInvalidJSONMode
+	}
+	if o.JSONMode != "" && o.CSVHeader {
+		return ErrCSVAndJSON
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"s3fc/codec"
+	"strings"
+	"text/template"
+)
+
+// DefaultKeyTemplate names destination objects with a UUID under their
+// partition's path
+const DefaultKeyTemplate = "{{if .Partition}}{{.Partition}}/{{end}}{{.UUID}}"
+
+var (
+	// ErrEmptyKey tells a caller that a key template rendered an empty key
+	ErrEmptyKey = errors.New("Key template rendered an empty key")
+	// ErrConstantKey tells a caller that a key template can render the same
+	// key for two destination objects of a partition
+	ErrConstantKey = errors.New("Invalid key_template, it must use .Sequence or .UUID")
+
+	compressedExts = map[string]bool{
+		".gz":  true,
+		".zst": true,
+		".bz2": true,
+		".sz":  true,
+	}
+)
+
+// KeyFields are the values available to an object set's key template when
+// naming a destination object.
+type KeyFields struct {
+	// Partition of the destination object, empty for the unnamed partition
+	Partition string
+	// Sequence the bolt database id of the destination object
+	Sequence uint64
+	// FirstKey key of the first source object, relative to the prefix
+	FirstKey string
+	// PlannedAt UTC time the destination object was planned, formatted as
+	// 20060102T150405Z
+	PlannedAt string
+	// Hash hex encoded sha256 of the keys and ETags of the source objects
+	Hash string
+	// Ext file extension of the first source object, e.g. ".json" or ".csv.gz"
+	Ext string
+	// UUID random, or derived from Hash when the object set uses
+	// deterministic keys
+	UUID string
+}
+
+// CompileKeyTemplate parses the object set's key template, or the default
+// template when none is set. It must be called before DestinationKey.
+func (o *ObjectSet) CompileKeyTemplate() (err error) {
+	text := o.KeyTemplate
+	if text == "" {
+		text = DefaultKeyTemplate
+	}
+
+	o.keyTemplate, err = template.New("key").Option("missingkey=error").Parse(text)
+	return err
+}
+
+// ValidateKeyTemplate rejects a key template that can name two destination
+// objects the same, by rendering it for two destination objects that only
+// differ in their Sequence and UUID. Destination objects planned together
+// share PlannedAt, and can share FirstKey or Hash once a source is planned
+// again, so only Sequence and UUID tell them apart.
+func (o *ObjectSet) ValidateKeyTemplate() error {
+	if err := o.CompileKeyTemplate(); err != nil {
+		return err
+	}
+
+	fields := KeyFields{
+		Partition: "p",
+		FirstKey:  "a",
+		PlannedAt: "20200101T000000Z",
+		Hash:      "a",
+	}
+
+	var a, b bytes.Buffer
+	fields.Sequence, fields.UUID = 1, "a"
+	if err := o.keyTemplate.Execute(&a, fields); err != nil {
+		return err
+	}
+	fields.Sequence, fields.UUID = 2, "b"
+	if err := o.keyTemplate.Execute(&b, fields); err != nil {
+		return err
+	}
+
+	if a.String() == b.String() {
+		return ErrConstantKey
+	}
+
+	return nil
+}
+
+// DestinationKey renders the object set's key template and joins it to the
+// destination path. The extensions of the object set's archive or framing
+// format and output codec are added when the key does not already end with
+// them.
+func (o *ObjectSet) DestinationKey(fields KeyFields) (string, error) {
+	if o.keyTemplate == nil {
+		if err := o.CompileKeyTemplate(); err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := o.keyTemplate.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+
+	key := CleanKey(buf.String())
+	if key == "" {
+		return "", ErrEmptyKey
+	}
+
+	if ext := o.formatExt() + codec.Ext(o.OutputCodec()); !strings.HasSuffix(key, ext) {
+		key += ext
+	}
+
+	return path.Join(o.DestinationPath, key), nil
+}
+
+// CleanKey makes a rendered key, or a partition, safe to join to a path.
+// Empty path segments are dropped and "." and ".." segments are escaped, so
+// that the key can not leave the path it is joined to.
+func CleanKey(key string) string {
+	segments := strings.Split(key, "/")
+	output := segments[:0]
+	for _, s := range segments {
+		switch s {
+		case "":
+			continue
+		case ".", "..":
+			s = strings.Repeat("%2E", len(s))
+		}
+		output = append(output, s)
+	}
+
+	return strings.Join(output, "/")
+}
+
+// KeyExt returns the file extension of a key. Compression extensions include
+// the extension before them, e.g. ".csv.gz".
+func KeyExt(key string) string {
+	ext := path.Ext(key)
+	if compressedExts[ext] {
+		return path.Ext(strings.TrimSuffix(key, ext)) + ext
+	}
+
+	return ext
+}
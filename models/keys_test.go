@@ -0,0 +1,135 @@
+package models
+
+import "testing"
+
+func TestDestinationKey(t *testing.T) {
+	fields := KeyFields{
+		Partition: "dt=2020-01-01",
+		Sequence:  7,
+		FirstKey:  "a/b.csv",
+		Ext:       ".csv",
+		UUID:      "uuid",
+	}
+
+	tests := []struct {
+		name     string
+		set      ObjectSet
+		fields   KeyFields
+		want     string
+		wantErr  error
+		template string
+	}{
+		{
+			name:   "default",
+			fields: fields,
+			want:   "out/dt=2020-01-01/uuid",
+		},
+		{
+			name:   "default without partition",
+			fields: KeyFields{UUID: "uuid"},
+			want:   "out/uuid",
+		},
+		{
+			name:     "sequence and extension",
+			template: `{{.Partition}}/part-{{printf "%05d" .Sequence}}{{.Ext}}`,
+			fields:   fields,
+			want:     "out/dt=2020-01-01/part-00007.csv",
+		},
+		{
+			name:   "codec extension",
+			set:    ObjectSet{Codec: "gzip"},
+			fields: fields,
+			want:   "out/dt=2020-01-01/uuid.gz",
+		},
+		{
+			name:     "extension not repeated",
+			set:      ObjectSet{Codec: "gzip"},
+			template: "{{.UUID}}.gz",
+			fields:   fields,
+			want:     "out/uuid.gz",
+		},
+		{
+			name:     "dot segments escaped",
+			template: "{{.FirstKey}}",
+			fields:   KeyFields{FirstKey: "../../a"},
+			want:     "out/%2E%2E/%2E%2E/a",
+		},
+		{
+			name:     "empty partition",
+			template: "{{.Partition}}/{{.UUID}}",
+			fields:   KeyFields{UUID: "uuid"},
+			want:     "out/uuid",
+		},
+		{
+			name:     "empty key",
+			template: "{{.Partition}}",
+			fields:   KeyFields{},
+			wantErr:  ErrEmptyKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := tt.set
+			set.DestinationPath = "out/"
+			set.KeyTemplate = tt.template
+
+			got, err := set.DestinationKey(tt.fields)
+			if err != tt.wantErr {
+				t.Fatalf("DestinationKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DestinationKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: ""},
+		{template: "{{.Partition}}/{{.Sequence}}"},
+		{template: "{{.PlannedAt}}-{{.UUID}}"},
+		{template: "{{.Partition}}/data", wantErr: true},
+		{template: "{{.PlannedAt}}", wantErr: true},
+		{template: "{{.FirstKey}}", wantErr: true},
+		{template: "{{.Hash}}", wantErr: true},
+		{template: "{{.Missing}}", wantErr: true},
+		{template: "{{", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			set := ObjectSet{KeyTemplate: tt.template}
+			if err := set.ValidateKeyTemplate(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateKeyTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "a/b", want: "a/b"},
+		{key: "/a//b/", want: "a/b"},
+		{key: "", want: ""},
+		{key: "..", want: "%2E%2E"},
+		{key: "a/./b", want: "a/%2E/b"},
+		{key: "a/../../b", want: "a/%2E%2E/%2E%2E/b"},
+		{key: "a..b/.c", want: "a..b/.c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := CleanKey(tt.key); got != tt.want {
+				t.Errorf("CleanKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
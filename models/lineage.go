@@ -0,0 +1,62 @@
+package models
+
+import (
+	"errors"
+	"s3fc/codec"
+	"s3fc/lineage"
+)
+
+var (
+	// ErrIndexMode tells a caller that a sidecar index can not be written for
+	// archives and framed records, which have entries of their own
+	ErrIndexMode = errors.New("index can not be combined with archive or framing")
+	// ErrNotRestorable tells a caller that source objects are not written to
+	// destination objects byte for byte and can not be restored from them
+	ErrNotRestorable = errors.New("source objects can not be restored byte for byte")
+)
+
+// ValidateIndex checks the object set's sidecar index configuration
+func (o *ObjectSet) ValidateIndex() error {
+	if err := lineage.Validate(o.Index); err != nil {
+		return err
+	}
+	if o.Index != lineage.None && (o.Archive != "" || o.Framing != "") {
+		return ErrIndexMode
+	}
+
+	return nil
+}
+
+// IndexKey the key of a destination object's sidecar index
+func (o *ObjectSet) IndexKey(key string) string {
+	return key + lineage.Ext(o.Index)
+}
+
+// ValidateRestore checks that source objects are written to the object set's
+// destination objects byte for byte, so that they can be restored from them.
+// Line transforms, BOM stripping, CSV header stripping and JSON parsing all
+// change the bytes of source objects.
+func (o *ObjectSet) ValidateRestore() error {
+	if len(o.Transforms) > 0 || o.StripBOM || o.CSVHeader || o.JSONMode != "" {
+		return ErrNotRestorable
+	}
+
+	return nil
+}
+
+// ValidateRestoreSource checks that a source object is written byte for byte.
+// A compressed source object, as named by the extension of its key, is
+// decompressed when source objects are decoded or when destination objects are
+// compressed with another codec.
+func (o *ObjectSet) ValidateRestoreSource(key string) error {
+	sourceCodec := codec.FromExt(key)
+	if sourceCodec == codec.None {
+		return nil
+	}
+	if o.DecodesSources() ||
+		(o.OutputCodec() != codec.None && sourceCodec != o.OutputCodec()) {
+		return ErrNotRestorable
+	}
+
+	return nil
+}
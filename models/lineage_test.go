@@ -0,0 +1,33 @@
+package models
+
+import "testing"
+
+func TestValidateRestoreSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     ObjectSet
+		key     string
+		wantErr bool
+	}{
+		{name: "uncompressed", set: ObjectSet{Codec: "zstd"}, key: "a.csv"},
+		{name: "copied as stored", key: "a.gz"},
+		{name: "output codec", set: ObjectSet{Codec: "gzip"}, key: "a.gz"},
+		{name: "gzip members", set: ObjectSet{GzipMembers: true}, key: "a.gz"},
+		{name: "other codec", set: ObjectSet{Codec: "zstd"}, key: "a.gz", wantErr: true},
+		{
+			name:    "decoded",
+			set:     ObjectSet{DelimiterMode: DelimiterIfMissing},
+			key:     "a.gz",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.set.ValidateRestoreSource(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRestoreSource(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
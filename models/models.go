@@ -2,6 +2,8 @@ package models
 
 import (
 	"path"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 
@@ -23,13 +25,22 @@ const (
 	StateExpired
 	// StateDeleted and object has been deleted.
 	StateDeleted
+	// StateFiltered a source object excluded by its object set's key filter.
+	// It will not be placed into a destination.
+	StateFiltered
+	// StateQuarantined a source object left out of its destination because its
+	// CSV header does not match the other source objects or it is not valid
+	// JSON.
+	StateQuarantined
 
-	stateUnknown = "UNKNOWN"
-	stateNew     = "NEW"
-	stateDirty   = "DIRTY"
-	stateInSync  = "IN_SYNC"
-	stateExpired = "EXPIRED"
-	stateDeleted = "DELETED"
+	stateUnknown     = "UNKNOWN"
+	stateNew         = "NEW"
+	stateDirty       = "DIRTY"
+	stateInSync      = "IN_SYNC"
+	stateExpired     = "EXPIRED"
+	stateDeleted     = "DELETED"
+	stateFiltered    = "FILTERED"
+	stateQuarantined = "QUARANTINED"
 )
 
 // State type wrapper for formatting uint16's as state strings
@@ -49,6 +60,10 @@ func (s State) String() string {
 		return stateExpired
 	case StateDeleted:
 		return stateDeleted
+	case StateFiltered:
+		return stateFiltered
+	case StateQuarantined:
+		return stateQuarantined
 	}
 
 	return stateUnknown
@@ -69,6 +84,10 @@ func ParseState(s string) uint16 {
 		return StateExpired
 	case stateDeleted:
 		return StateDeleted
+	case stateFiltered:
+		return StateFiltered
+	case stateQuarantined:
+		return StateQuarantined
 	}
 
 	return StateUnknown
@@ -86,7 +105,92 @@ type ObjectSet struct {
 	Delimiter         []byte
 	BlockSize         int64
 
-	tableName []byte
+	// DelimiterMode whether the delimiter is written after every source
+	// object or only after those that do not end with it, and StripBOM strips
+	// a UTF-8 byte order mark from the start of source objects
+	DelimiterMode string
+	StripBOM      bool
+	// DelimiterPlacement whether the delimiter follows every source object
+	// or only separates them, Header and Footer are written at the start and
+	// the end of every destination object
+	DelimiterPlacement string
+	Header             []byte
+	Footer             []byte
+
+	// GzipMembers concatenates gzip source objects as they are and wraps the
+	// delimiter and any other source object in gzip members of their own, so
+	// that destination objects are multi-member gzip files
+	GzipMembers bool
+	// Codec compresses destination objects, source objects compressed with
+	// another codec are decompressed before they are concatenated
+	Codec string
+	// BlockSizeTarget what the block size is compared to and CompressionRatio
+	// the expected ratio of uncompressed to compressed bytes used to estimate
+	// it
+	BlockSizeTarget  string
+	CompressionRatio float64
+
+	// CSVHeader writes the header row of the first source object once and
+	// strips it from the others, HeaderMismatch handles source objects whose
+	// header does not match and rejects them by default
+	CSVHeader      bool
+	HeaderMismatch string
+
+	// JSONMode merges source objects into a single JSON array or normalizes
+	// them to JSON Lines instead of concatenating them with the delimiter,
+	// it can not be combined with line transforms
+	JSONMode string
+
+	// Archive writes destination objects as tar or zip files with an entry
+	// for every source object instead of concatenating them
+	Archive string
+	// Framing writes every source object as a length-prefixed record, with a
+	// CRC-32C when FrameCRC is set, instead of concatenating them
+	Framing  string
+	FrameCRC bool
+
+	// Index writes a sidecar object next to every destination object with the
+	// byte range of each of its source objects
+	Index string
+
+	// Transforms a chain of line-oriented operations applied to the
+	// decompressed source objects as they are concatenated
+	Transforms Transforms
+
+	// DeleteSources deletes source objects once their destination object is
+	// IN_SYNC and has been verified for Retention
+	DeleteSources bool
+	Retention     time.Duration
+
+	Partitioning *Partitioning
+
+	// KeyTemplate text/template rendering the key of destination objects
+	// relative to the destination path from their KeyFields
+	KeyTemplate string
+	// DeterministicKeys derives the UUID of destination objects from their
+	// source objects so that planning the same sources renders the same key
+	DeterministicKeys bool
+
+	// Planning configuration
+	Packing    string
+	SortBy     string
+	MaxSources int64
+
+	// MinBlockSize holds back destination objects smaller than it until their
+	// oldest source object is older than MaxAge
+	MinBlockSize int64
+	MaxAge       time.Duration
+
+	// TopUp reopens the most recent under-filled destination object of a
+	// partition and replaces it with one that includes new sources
+	TopUp bool
+
+	// Source configuration
+	Filter *KeyFilter
+
+	tableName     []byte
+	keyTemplate   *template.Template
+	gzipDelimiter []byte
 }
 
 // NewObjectSet instantiates a new ObjectSet from its primary key values of
@@ -118,9 +222,11 @@ func NewObject(p ObjectSet) *Object {
 }
 
 // IsDirty compares the receiver Object with the passed object. If their ETags's
-// do not match the receiver is flag as dirty.
+// do not match, or the passed object was deleted and the receiver recreates it,
+// the receiver is flag as dirty.
 func (o *Object) IsDirty(other Object) bool {
-	if aws.StringValue(o.ETag) == aws.StringValue(other.ETag) {
+	if other.State != StateDeleted &&
+		aws.StringValue(o.ETag) == aws.StringValue(other.ETag) {
 		return false
 	}
 
@@ -133,7 +239,12 @@ func (o *Object) IsDirty(other Object) bool {
 // to a DestinationObject
 type SourceObject struct {
 	Object
+	VersionID           *string
 	DestinationObjectID []byte
+	// PreviousDestinationObjectID is the destination object a source object
+	// was written to before it was planned into a replacing destination object.
+	// It is cleared once the previous destination object is expired.
+	PreviousDestinationObjectID []byte
 }
 
 // NewSourceObject instantiates a new SourceObject declaring it a member of the
@@ -151,6 +262,14 @@ func NewSourceObject(p ObjectSet) *SourceObject {
 // concatination
 type DestinationObject struct {
 	Object
+	Partition string
+	// VerifiedAt when the written destination object's size and checksum were
+	// checked, source objects are never deleted before it is set
+	VerifiedAt *time.Time
+	// WrittenSize the number of bytes written to the destination object, which
+	// differs from its planned Size when source objects were transformed,
+	// compressed or left out
+	WrittenSize *int64
 }
 
 // NewDestinationObject instantiates a new DestinationObject declaring it a
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDeleteSourcesTopUp tells a caller that source objects can not be
+	// deleted when destination objects are topped up, as topping up rewrites
+	// them from their source objects
+	ErrDeleteSourcesTopUp = errors.New("delete_sources can not be combined with top_up")
+	// ErrInvalidRetention tells a caller that the retention is negative
+	ErrInvalidRetention = errors.New("Invalid retention, expected a positive duration")
+)
+
+// ValidateMove checks the object set's source deletion configuration
+func (o *ObjectSet) ValidateMove() error {
+	if o.Retention < 0 {
+		return ErrInvalidRetention
+	}
+	if o.DeleteSources && o.TopUp {
+		return ErrDeleteSourcesTopUp
+	}
+
+	return nil
+}
+
+// IsDeletable reports whether the source objects of a destination object
+// verified at verifiedAt can be deleted, the object set deletes source objects
+// and the retention has passed since the destination object was verified
+func (o *ObjectSet) IsDeletable(verifiedAt *time.Time, now time.Time) bool {
+	if !o.DeleteSources || verifiedAt == nil {
+		return false
+	}
+
+	return now.Sub(*verifiedAt) >= o.Retention
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+const (
+	// PartitionByLastModified partitions source objects by formatting their
+	// LastModified time with the partition layout
+	PartitionByLastModified = "last_modified"
+	// PartitionByKeyRegex partitions source objects by expanding the partition
+	// layout with the capture groups of the partition pattern matched against
+	// their key
+	PartitionByKeyRegex = "key_regex"
+
+	defaultTimeLayout     = "dt=2006-01-02"
+	defaultKeyRegexLayout = "${1}"
+)
+
+var (
+	// ErrInvalidPartitioning tells a caller that the partitioning configuration
+	// is not supported
+	ErrInvalidPartitioning = errors.New("Invalid partition_by, expected \"last_modified\" or \"key_regex\"")
+	// ErrMissingPartitionPattern tells a caller that key_regex partitioning is
+	// missing its pattern
+	ErrMissingPartitionPattern = errors.New("Missing required parameter, partition_pattern")
+)
+
+// Partitioning groups the source objects of an ObjectSet into partitions that
+// are planned into separate destination objects written under the partition's
+// path, e.g. "dt=2020-01-31/". Keys are matched relative to the prefix, keys
+// that do not match the pattern belong to the unnamed partition.
+type Partitioning struct {
+	By      string `json:"partition_by,omitempty"`
+	Layout  string `json:"partition_layout,omitempty"`
+	Pattern string `json:"partition_pattern,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// IsEmpty reports whether the partitioning is not configured
+func (p *Partitioning) IsEmpty() bool {
+	return p.By == ""
+}
+
+// Compile validates the partitioning and compiles its pattern. It must be
+// called before Partition.
+func (p *Partitioning) Compile() (err error) {
+	switch p.By {
+	case "":
+		return nil
+	case PartitionByLastModified:
+		if p.Layout == "" {
+			p.Layout = defaultTimeLayout
+		}
+		return nil
+	case PartitionByKeyRegex:
+		if p.Pattern == "" {
+			return ErrMissingPartitionPattern
+		}
+		if p.Layout == "" {
+			p.Layout = defaultKeyRegexLayout
+		}
+		p.re, err = regexp.Compile(p.Pattern)
+		return err
+	}
+
+	return ErrInvalidPartitioning
+}
+
+// Partition returns the partition of an object from its key, relative to the
+// object set's prefix, and its LastModified time. The partition is cleaned
+// with CleanKey, so that it stays under the destination path.
+func (p *Partitioning) Partition(key string, lastModified time.Time) string {
+	switch p.By {
+	case PartitionByLastModified:
+		return CleanKey(lastModified.UTC().Format(p.Layout))
+	case PartitionByKeyRegex:
+		match := p.re.FindStringSubmatchIndex(key)
+		if match == nil {
+			return ""
+		}
+		return CleanKey(string(p.re.ExpandString(nil, p.Layout, key, match)))
+	}
+
+	return ""
+}
+
+// Partition returns the partition of a source object from its key, relative to
+// the object set's prefix, and its LastModified time. Object sets without
+// partitioning only have the unnamed partition.
+func (o *ObjectSet) Partition(key string, lastModified time.Time) string {
+	if o.Partitioning == nil {
+		return ""
+	}
+
+	return o.Partitioning.Partition(key, lastModified)
+}
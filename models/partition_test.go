@@ -0,0 +1,73 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	modified := time.Date(2020, 1, 31, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		partitioning *Partitioning
+		key          string
+		want         string
+	}{
+		{name: "none", key: "a/b", want: ""},
+		{
+			name:         "last modified",
+			partitioning: &Partitioning{By: PartitionByLastModified},
+			key:          "a",
+			want:         "dt=2020-01-31",
+		},
+		{
+			name: "key regex",
+			partitioning: &Partitioning{
+				By:      PartitionByKeyRegex,
+				Pattern: `^(?P<date>\d{4}-\d{2}-\d{2})/`,
+				Layout:  "dt=${date}",
+			},
+			key:  "2020-01-31/a",
+			want: "dt=2020-01-31",
+		},
+		{
+			name:         "key regex without match",
+			partitioning: &Partitioning{By: PartitionByKeyRegex, Pattern: `^(\d+)/`},
+			key:          "a",
+			want:         "",
+		},
+		{
+			name:         "dot segments escaped",
+			partitioning: &Partitioning{By: PartitionByKeyRegex, Pattern: `^([^/]+/[^/]+)/`},
+			key:          "../../a",
+			want:         "%2E%2E/%2E%2E",
+		},
+		{
+			name:         "leading slash dropped",
+			partitioning: &Partitioning{By: PartitionByKeyRegex, Pattern: `^(/?\w*)/`},
+			key:          "/a/b",
+			want:         "a",
+		},
+		{
+			name:         "empty capture",
+			partitioning: &Partitioning{By: PartitionByKeyRegex, Pattern: `^(\w*)/`},
+			key:          "/b",
+			want:         "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := ObjectSet{Partitioning: tt.partitioning}
+			if tt.partitioning != nil {
+				if err := tt.partitioning.Compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := set.Partition(tt.key, modified); got != tt.want {
+				t.Errorf("Partition(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
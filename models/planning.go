@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// PackingGreedy adds sources to a destination object until it reaches the
+	// block size, destination objects are usually larger than the block size.
+	PackingGreedy = "greedy"
+	// PackingNoExceed starts a new destination object instead of exceeding the
+	// block size. A source larger than the block size is its own destination.
+	PackingNoExceed = "no_exceed"
+	// PackingFirstFitDecreasing places sources from largest to smallest into
+	// the first destination object with room for them.
+	PackingFirstFitDecreasing = "first_fit_decreasing"
+
+	// SortByKey plans sources in key order
+	SortByKey = "key"
+	// SortByLastModified plans sources from oldest to newest
+	SortByLastModified = "last_modified"
+)
+
+var (
+	// ErrInvalidPacking tells a caller that the packing strategy is not
+	// supported
+	ErrInvalidPacking = errors.New("Invalid packing, expected \"greedy\", \"no_exceed\" or \"first_fit_decreasing\"")
+	// ErrInvalidSortBy tells a caller that the source order is not supported
+	ErrInvalidSortBy = errors.New("Invalid sort_by, expected \"key\" or \"last_modified\"")
+	// ErrInvalidMinBlockSize tells a caller that the minimum block size is
+	// negative or larger than the block size
+	ErrInvalidMinBlockSize = errors.New("Invalid min_block_size, expected a value between 0 and block_size")
+	// ErrInvalidMaxAge tells a caller that the maximum age is negative
+	ErrInvalidMaxAge = errors.New("Invalid max_age, expected a positive duration")
+)
+
+// ValidatePlanning checks the object set's planning configuration
+func (o *ObjectSet) ValidatePlanning() error {
+	switch o.Packing {
+	case "", PackingGreedy, PackingNoExceed, PackingFirstFitDecreasing:
+	default:
+		return ErrInvalidPacking
+	}
+
+	switch o.SortBy {
+	case "", SortByKey, SortByLastModified:
+	default:
+		return ErrInvalidSortBy
+	}
+
+	if o.MinBlockSize < 0 || o.MinBlockSize > o.BlockSize {
+		return ErrInvalidMinBlockSize
+	}
+	if o.MaxAge < 0 {
+		return ErrInvalidMaxAge
+	}
+
+	return nil
+}
+
+// IsPending reports whether sources of a destination object of the given size
+// should stay NEW until a later run because it is smaller than the minimum
+// block size and its oldest source, last modified at oldest, has not reached
+// the maximum age yet.
+func (o *ObjectSet) IsPending(size int64, oldest time.Time, now time.Time) bool {
+	if size >= o.MinBlockSize {
+		return false
+	}
+
+	return o.MaxAge == 0 || now.Sub(oldest) < o.MaxAge
+}
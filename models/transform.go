@@ -0,0 +1,122 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+const (
+	// TransformFilter keeps the lines that match the pattern, or with Invert
+	// the lines that do not
+	TransformFilter = "filter"
+	// TransformReplace replaces the matches of the pattern with the
+	// replacement, which may refer to capture groups, e.g. ${1}
+	TransformReplace = "replace"
+	// TransformAnnotate prefixes every line with its source object's key and
+	// the separator
+	TransformAnnotate = "annotate"
+	// TransformDropEmpty drops lines that are empty or only white space
+	TransformDropEmpty = "drop_empty"
+
+	defaultAnnotateSeparator = "\t"
+)
+
+var (
+	// ErrInvalidTransform tells a caller that a transform operation is not
+	// supported
+	ErrInvalidTransform = errors.New("Invalid transform op, expected \"filter\", \"replace\", \"annotate\" or \"drop_empty\"")
+	// ErrMissingTransformPattern tells a caller that a filter or replace
+	// transform is missing its pattern
+	ErrMissingTransformPattern = errors.New("Missing required transform parameter, pattern")
+	// ErrTransformMode tells a caller that line transforms can not be combined
+	// with options that copy source objects as they are or parse them
+	ErrTransformMode = errors.New("transforms can not be combined with gzip_members, json_mode, archive or framing")
+)
+
+// Transform a line-oriented operation applied to the decompressed source
+// objects of a destination object
+type Transform struct {
+	Op          string `json:"op"`
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	Invert      bool   `json:"invert,omitempty"`
+	Separator   string `json:"separator,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Transforms a chain of transforms applied to every line in order
+type Transforms []Transform
+
+// Compile validates the transforms and compiles their patterns. It must be
+// called before Apply.
+func (t Transforms) Compile() (err error) {
+	for i := range t {
+		switch t[i].Op {
+		case TransformFilter, TransformReplace:
+			if t[i].Pattern == "" {
+				return ErrMissingTransformPattern
+			}
+			if t[i].re, err = regexp.Compile(t[i].Pattern); err != nil {
+				return err
+			}
+		case TransformAnnotate:
+			if t[i].Separator == "" {
+				t[i].Separator = defaultAnnotateSeparator
+			}
+		case TransformDropEmpty:
+		default:
+			return ErrInvalidTransform
+		}
+	}
+
+	return nil
+}
+
+// Apply transforms a line of a source object with the passed key. The line
+// ending, if any, is not passed to the transforms and is kept as it is. It
+// reports false when the line is dropped.
+func (t Transforms) Apply(line []byte, key string) ([]byte, bool) {
+	content := bytes.TrimRight(line, "\r\n")
+	eol := line[len(content):]
+
+	for _, transform := range t {
+		switch transform.Op {
+		case TransformFilter:
+			if transform.re.Match(content) == transform.Invert {
+				return nil, false
+			}
+		case TransformReplace:
+			content = transform.re.ReplaceAll(content, []byte(transform.Replacement))
+		case TransformAnnotate:
+			annotated := make([]byte, 0, len(key)+len(transform.Separator)+len(content))
+			annotated = append(annotated, key...)
+			annotated = append(annotated, transform.Separator...)
+			content = append(annotated, content...)
+		case TransformDropEmpty:
+			if len(bytes.TrimSpace(content)) == 0 {
+				return nil, false
+			}
+		}
+	}
+
+	output := make([]byte, 0, len(content)+len(eol))
+	output = append(output, content...)
+	return append(output, eol...), true
+}
+
+// ValidateTransforms checks the object set's line transforms
+func (o *ObjectSet) ValidateTransforms() error {
+	if len(o.Transforms) == 0 {
+		return nil
+	}
+	if err := o.Transforms.Compile(); err != nil {
+		return err
+	}
+	if o.GzipMembers || o.JSONMode != "" || o.Archive != "" || o.Framing != "" {
+		return ErrTransformMode
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package planner
+
+import (
+	"s3fc/boltdb"
+	"s3fc/models"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Deletable queries for the IN_SYNC source objects of the IN_SYNC destination
+// objects that were verified at least the object set's retention before now.
+// When limit is positive sources are collected until it is reached, the
+// sources of a destination object are never split.
+func Deletable(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	now time.Time,
+	limit int,
+) ([][]byte, []models.SourceObject, error) {
+	var ids [][]byte
+	var sources []models.SourceObject
+
+	err := scan(
+		b,
+		[]byte(destinationStateIndex),
+		boltdb.Uint16tol(models.StateInSync),
+		func(destID []byte) error {
+			if limit > 0 && len(sources) >= limit {
+				return nil
+			}
+
+			dest := models.NewDestinationObject(set)
+			if err := boltdb.LookupRow(b, destID, dest); err != nil {
+				return err
+			}
+			if !set.IsDeletable(dest.VerifiedAt, now) {
+				return nil
+			}
+
+			return scan(b, []byte(destinationIndex), destID, func(id []byte) error {
+				row := models.NewSourceObject(set)
+				if err := boltdb.LookupRow(b, id, row); err != nil {
+					return err
+				}
+				if row.State != models.StateInSync {
+					return nil
+				}
+
+				ids = append(ids, id)
+				sources = append(sources, *row)
+				return nil
+			})
+		},
+	)
+
+	return ids, sources, err
+}
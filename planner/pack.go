@@ -0,0 +1,271 @@
+package planner
+
+import (
+	"s3fc/models"
+	"sort"
+	"time"
+)
+
+// Pack groups sources into blocks as per the object set's packing strategy,
+// source order, and maximum number of sources per destination object. Every
+// partition is packed separately.
+func Pack(set models.ObjectSet, sources []Source) []Block {
+	sortSources(set.SortBy, sources)
+
+	if set.Packing == models.PackingFirstFitDecreasing {
+		var blocks []Block
+		for _, partition := range partitions(sources) {
+			blocks = append(blocks, packFirstFitDecreasing(set, partition)...)
+		}
+		return blocks
+	}
+
+	return packSequential(set, sources)
+}
+
+// packSequential adds sources in order to their partition's block. Greedy
+// packing closes a block once it reaches the block size, "no exceed" packing
+// closes it before a source would take it over the block size. The trailing
+// block of every partition may be smaller than the block size.
+func packSequential(set models.ObjectSet, sources []Source) []Block {
+	var blocks []Block
+	var order []string
+	open := make(map[string]*Block)
+	noExceed := set.Packing == models.PackingNoExceed
+
+	for _, s := range sources {
+		block, ok := open[s.Partition]
+		if !ok {
+			block = newBlock(set, s.Partition)
+			open[s.Partition] = block
+			order = append(order, s.Partition)
+		}
+
+		size := SourceSize(set, s)
+		if noExceed && len(block.Sources) > 0 && block.Size+size > set.BlockSize {
+			blocks = append(blocks, *block)
+			*block = *newBlock(set, s.Partition)
+		}
+
+		block.Sources = append(block.Sources, s)
+		block.Size += size
+		if block.Size >= set.BlockSize || isFull(set, block) {
+			blocks = append(blocks, *block)
+			*block = *newBlock(set, s.Partition)
+		}
+	}
+
+	for _, p := range order {
+		if block := open[p]; len(block.Sources) > 0 {
+			blocks = append(blocks, *block)
+		}
+	}
+
+	return blocks
+}
+
+// packFirstFitDecreasing places the sources of a single partition, from
+// largest to smallest, into the first block that has room for them without
+// exceeding the block size.
+func packFirstFitDecreasing(set models.ObjectSet, sources []Source) []Block {
+	if len(sources) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Size > sources[j].Size
+	})
+	smallest := SourceSize(set, sources[len(sources)-1])
+
+	var blocks []*Block
+	var open []*Block
+	for _, s := range sources {
+		size := SourceSize(set, s)
+
+		var block *Block
+		for _, b := range open {
+			if b.Size+size <= set.BlockSize {
+				block = b
+				break
+			}
+		}
+		if block == nil {
+			block = newBlock(set, s.Partition)
+			blocks = append(blocks, block)
+			open = append(open, block)
+		}
+
+		block.Sources = append(block.Sources, s)
+		block.Size += size
+
+		// stop considering blocks that no remaining source can fit into
+		if isFull(set, block) || block.Size+smallest > set.BlockSize {
+			for i, b := range open {
+				if b == block {
+					open = append(open[:i], open[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	output := make([]Block, 0, len(blocks))
+	for _, b := range blocks {
+		output = append(output, *b)
+	}
+
+	return output
+}
+
+// Ready drops the blocks that are still pending as per the object set's
+// minimum block size and maximum age, so that their sources stay NEW and are
+// packed again by a later run. Only the last block of a partition, the one
+// still growing, can be pending, and never when it holds the maximum number of
+// sources. When one block with sources of a reopened destination object is
+// pending all of them are, so that the reopened destination object is replaced
+// as a whole.
+func Ready(set models.ObjectSet, blocks []Block, now time.Time) []Block {
+	if set.MinBlockSize == 0 {
+		return blocks
+	}
+
+	last := make(map[string]int)
+	for i, block := range blocks {
+		last[block.Partition] = i
+	}
+
+	pending := make([]bool, len(blocks))
+	held := make(map[string]bool)
+	for i, block := range blocks {
+		pending[i] = last[block.Partition] == i && !isFull(set, &block) &&
+			set.IsPending(block.Size, oldest(block), now)
+		if pending[i] {
+			for _, id := range reopenedFrom(block) {
+				held[string(id)] = true
+			}
+		}
+	}
+
+	output := blocks[:0]
+	for i, block := range blocks {
+		if !pending[i] && !isHeld(held, block) {
+			output = append(output, block)
+		}
+	}
+
+	return output
+}
+
+// Limit takes blocks in order while they stay within maxDestinations and
+// maxBytes, a limit of 0 is no limit. The first block is always taken so that
+// every call makes progress, and blocks with sources of a reopened destination
+// object are taken together so that it is replaced as a whole. It reports
+// whether any block was left out.
+func Limit(blocks []Block, maxDestinations int, maxBytes int64) ([]Block, bool) {
+	n := 0
+	var size int64
+	for ; n < len(blocks); n++ {
+		size += blocks[n].Size
+		if n > 0 && ((maxDestinations > 0 && n >= maxDestinations) ||
+			(maxBytes > 0 && size > maxBytes)) {
+			break
+		}
+	}
+	if n == len(blocks) {
+		return blocks, false
+	}
+
+	taken := make(map[string]bool)
+	for _, block := range blocks[:n] {
+		for _, id := range reopenedFrom(block) {
+			taken[string(id)] = true
+		}
+	}
+
+	output := blocks[:n:n]
+	rest := blocks[n:]
+	for changed := true; changed; {
+		changed = false
+		left := rest[:0:0]
+		for _, block := range rest {
+			if !isHeld(taken, block) {
+				left = append(left, block)
+				continue
+			}
+			for _, id := range reopenedFrom(block) {
+				taken[string(id)] = true
+			}
+			output = append(output, block)
+			changed = true
+		}
+		rest = left
+	}
+
+	return output, len(rest) > 0
+}
+
+// isHeld reports whether any of a block's sources was reopened from one of the
+// passed destination objects
+func isHeld(held map[string]bool, block Block) bool {
+	for _, id := range reopenedFrom(block) {
+		if held[string(id)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// oldest returns the last modified time of a block's oldest source
+func oldest(block Block) time.Time {
+	var t time.Time
+	for i, s := range block.Sources {
+		if i == 0 || s.LastModified.Before(t) {
+			t = s.LastModified
+		}
+	}
+
+	return t
+}
+
+// newBlock starts an empty block, sized for the object set's header and footer
+func newBlock(set models.ObjectSet, partition string) *Block {
+	return &Block{Partition: partition, Size: set.FrameSize()}
+}
+
+// isFull reports whether a block has reached the object set's maximum number
+// of sources per destination object
+func isFull(set models.ObjectSet, block *Block) bool {
+	return set.MaxSources > 0 && int64(len(block.Sources)) >= set.MaxSources
+}
+
+// partitions splits sources by partition, in order of each partition's first
+// source
+func partitions(sources []Source) [][]Source {
+	var output [][]Source
+	index := make(map[string]int)
+	for _, s := range sources {
+		i, ok := index[s.Partition]
+		if !ok {
+			i = len(output)
+			index[s.Partition] = i
+			output = append(output, nil)
+		}
+		output[i] = append(output[i], s)
+	}
+
+	return output
+}
+
+func sortSources(sortBy string, sources []Source) {
+	switch sortBy {
+	case models.SortByKey:
+		sort.SliceStable(sources, func(i, j int) bool {
+			return sources[i].Key < sources[j].Key
+		})
+	case models.SortByLastModified:
+		sort.SliceStable(sources, func(i, j int) bool {
+			return sources[i].LastModified.Before(sources[j].LastModified)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package planner
+
+import (
+	"reflect"
+	"s3fc/models"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func source(key string, size int64, partition string) Source {
+	return Source{
+		ID:           []byte(key),
+		Key:          key,
+		Size:         size,
+		LastModified: epoch,
+		Partition:    partition,
+	}
+}
+
+// blockKeys lists the source keys of every block
+func blockKeys(blocks []Block) [][]string {
+	output := make([][]string, 0, len(blocks))
+	for _, b := range blocks {
+		keys := make([]string, 0, len(b.Sources))
+		for _, s := range b.Sources {
+			keys = append(keys, s.Key)
+		}
+		output = append(output, keys)
+	}
+
+	return output
+}
+
+func TestPackPartitions(t *testing.T) {
+	sources := []Source{
+		source("a", 4, "x"),
+		source("b", 4, "y"),
+		source("c", 4, "x"),
+		source("d", 4, "y"),
+		source("e", 4, "x"),
+	}
+
+	blocks := Pack(models.ObjectSet{BlockSize: 8}, sources)
+	want := [][]string{{"a", "c"}, {"b", "d"}, {"e"}}
+	if got := blockKeys(blocks); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pack() = %v, want %v", got, want)
+	}
+	for _, b := range blocks {
+		for _, s := range b.Sources {
+			if s.Partition != b.Partition {
+				t.Errorf("source %s of partition %q in block of %q", s.Key, s.Partition, b.Partition)
+			}
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	sources := []Source{
+		source("a", 4, ""),
+		source("b", 4, ""),
+		source("c", 7, ""),
+		source("d", 2, ""),
+		source("e", 3, ""),
+	}
+
+	tests := []struct {
+		name string
+		set  models.ObjectSet
+		want [][]string
+	}{
+		{
+			name: "greedy",
+			set:  models.ObjectSet{BlockSize: 8},
+			want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name: "no exceed",
+			set:  models.ObjectSet{BlockSize: 8, Packing: models.PackingNoExceed},
+			want: [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+		},
+		{
+			name: "first fit decreasing",
+			set: models.ObjectSet{
+				BlockSize: 8,
+				Packing:   models.PackingFirstFitDecreasing,
+			},
+			want: [][]string{{"c"}, {"a", "b"}, {"e", "d"}},
+		},
+		{
+			name: "max sources",
+			set:  models.ObjectSet{BlockSize: 100, MaxSources: 2},
+			want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name: "sort by key",
+			set:  models.ObjectSet{BlockSize: 6, SortBy: models.SortByKey},
+			want: [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]Source{}, sources...)
+			got := blockKeys(Pack(tt.set, input))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pack() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	now := epoch.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		set    models.ObjectSet
+		blocks []Block
+		want   [][]string
+	}{
+		{
+			name: "no minimum",
+			set:  models.ObjectSet{BlockSize: 8},
+			blocks: []Block{
+				{Sources: []Source{source("a", 2, "")}, Size: 2},
+			},
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "only the last block of a partition is pending",
+			set:  models.ObjectSet{BlockSize: 8, MinBlockSize: 4},
+			blocks: []Block{
+				{Partition: "x", Sources: []Source{source("a", 2, "x")}, Size: 2},
+				{Partition: "y", Sources: []Source{source("b", 2, "y")}, Size: 2},
+				{Partition: "x", Sources: []Source{source("c", 2, "x")}, Size: 2},
+				{Partition: "y", Sources: []Source{source("d", 6, "y")}, Size: 6},
+			},
+			want: [][]string{{"a"}, {"b"}, {"d"}},
+		},
+		{
+			name: "max age reached",
+			set: models.ObjectSet{
+				BlockSize:    8,
+				MinBlockSize: 4,
+				MaxAge:       time.Minute,
+			},
+			blocks: []Block{
+				{Sources: []Source{source("a", 2, "")}, Size: 2},
+			},
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "max sources",
+			set: models.ObjectSet{
+				BlockSize:    8,
+				MinBlockSize: 4,
+				MaxSources:   1,
+			},
+			blocks: []Block{
+				{Sources: []Source{source("a", 2, "")}, Size: 2},
+			},
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "reopened destination is held as a whole",
+			set:  models.ObjectSet{BlockSize: 8, MinBlockSize: 4},
+			blocks: []Block{
+				{Sources: []Source{reopened("a", 6, "1")}, Size: 6},
+				{Sources: []Source{reopened("b", 2, "1")}, Size: 2},
+			},
+			want: [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := blockKeys(Ready(tt.set, tt.blocks, now))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Ready() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func reopened(key string, size int64, destID string) Source {
+	s := source(key, size, "")
+	s.DestinationID = []byte(destID)
+	return s
+}
+
+func TestLimit(t *testing.T) {
+	blocks := []Block{
+		{Sources: []Source{source("a", 4, "")}, Size: 4},
+		{Sources: []Source{source("b", 4, "")}, Size: 4},
+		{Sources: []Source{source("c", 4, "")}, Size: 4},
+	}
+
+	tests := []struct {
+		name            string
+		blocks          []Block
+		maxDestinations int
+		maxBytes        int64
+		want            [][]string
+		remaining       bool
+	}{
+		{
+			name:   "no limit",
+			blocks: blocks,
+			want:   [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:            "max destinations",
+			blocks:          blocks,
+			maxDestinations: 2,
+			want:            [][]string{{"a"}, {"b"}},
+			remaining:       true,
+		},
+		{
+			name:      "max bytes",
+			blocks:    blocks,
+			maxBytes:  9,
+			want:      [][]string{{"a"}, {"b"}},
+			remaining: true,
+		},
+		{
+			name:      "first block is always taken",
+			blocks:    blocks,
+			maxBytes:  1,
+			want:      [][]string{{"a"}},
+			remaining: true,
+		},
+		{
+			name: "reopened destination is taken as a whole",
+			blocks: []Block{
+				{Sources: []Source{reopened("a", 4, "1")}, Size: 4},
+				{Sources: []Source{source("b", 4, "")}, Size: 4},
+				{Sources: []Source{reopened("c", 4, "1")}, Size: 4},
+			},
+			maxDestinations: 1,
+			want:            [][]string{{"a"}, {"c"}},
+			remaining:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]Block{}, tt.blocks...)
+			got, remaining := Limit(input, tt.maxDestinations, tt.maxBytes)
+			if !reflect.DeepEqual(blockKeys(got), tt.want) {
+				t.Errorf("Limit() = %v, want %v", blockKeys(got), tt.want)
+			}
+			if remaining != tt.remaining {
+				t.Errorf("Limit() remaining = %v, want %v", remaining, tt.remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package planner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"s3fc/boltdb"
+	"s3fc/framing"
+	"s3fc/models"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+)
+
+const sourceStateIndex = "idx_source_state"
+
+// ErrDuplicateKey tells a caller that the key template named a destination
+// object with the key of another destination object
+var ErrDuplicateKey = errors.New("Destination key is already taken")
+
+// Source is a source object waiting to be planned into a destination object
+type Source struct {
+	ID           []byte
+	Key          string
+	ETag         string
+	Size         int64
+	LastModified time.Time
+	Partition    string
+
+	// DestinationID is the destination object a reopened source was
+	// previously written to
+	DestinationID []byte
+}
+
+// Block is a planned destination object and the sources concatenated into it
+type Block struct {
+	Partition string
+	Sources   []Source
+	Size      int64
+}
+
+// batchSize the number of source objects read at a time, and the number of
+// read source objects queued before they are packed
+const batchSize = 2048
+
+// Planner reads the source objects of an object set in a state in batches of
+// 2048 and packs them as they are read. It only holds the sources of the block
+// still open in every partition and a batch of sources waiting to be packed,
+// the blocks that are closed are returned as soon as they are packed. Sorting
+// and first fit decreasing packing order a batch of sources at a time.
+type Planner struct {
+	set      models.ObjectSet
+	state    uint16
+	reopened map[string][]byte
+
+	exclusiveStart []byte
+	done           bool
+	read           int
+
+	queued map[string][]Source
+	order  []string
+	size   int
+	held   int
+}
+
+// New starts planning the source objects of an object set in the passed
+// state. When the object set tops up, the sources of the most recent
+// under-filled destination object of a partition are planned ahead of the
+// partition's first source.
+func New(b *bolt.Bucket, set models.ObjectSet, state uint16) (*Planner, error) {
+	p := &Planner{
+		set:    set,
+		state:  state,
+		queued: make(map[string][]Source),
+	}
+	if set.TopUp {
+		var err error
+		if p.reopened, err = Reopen(b, set); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Done reports whether every source object has been read
+func (p *Planner) Done() bool {
+	return p.done
+}
+
+// Read returns the number of source objects read so far
+func (p *Planner) Read() int {
+	return p.read
+}
+
+// Next reads the next batch of source objects and returns the blocks that
+// are closed once they are packed
+func (p *Planner) Next(b *bolt.Bucket) ([]Block, error) {
+	if p.done {
+		return nil, nil
+	}
+
+	prefix := boltdb.Uint16tol(p.state)
+	ids, err := boltdb.PrefixQuery(
+		b, []byte(sourceStateIndex), prefix, batchSize, p.exclusiveStart,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) < batchSize {
+		p.done = true
+	} else {
+		p.exclusiveStart = boltdb.MakeIndex(prefix, ids[len(ids)-1])
+	}
+
+	for _, id := range ids {
+		row := models.NewSourceObject(p.set)
+		if err = boltdb.LookupRow(b, id, row); err != nil {
+			return nil, err
+		}
+		if err = p.queue(b, newSource(p.set, id, row)); err != nil {
+			return nil, err
+		}
+	}
+	p.read += len(ids)
+
+	if p.size < p.held+batchSize {
+		return nil, nil
+	}
+	return p.pack(false), nil
+}
+
+// Close packs the sources still queued once every source object has been read
+// and returns the blocks that are ready to be written
+func (p *Planner) Close(now time.Time) []Block {
+	return Ready(p.set, p.pack(true), now)
+}
+
+// queue adds a source to its partition's queue, behind the sources of the
+// destination object reopened for the partition
+func (p *Planner) queue(b *bolt.Bucket, s Source) error {
+	if _, ok := p.queued[s.Partition]; !ok {
+		p.order = append(p.order, s.Partition)
+		p.queued[s.Partition] = []Source{}
+
+		if id := p.reopened[s.Partition]; id != nil {
+			sources, err := DestinationSources(b, p.set, id)
+			if err != nil {
+				return err
+			}
+			p.queued[s.Partition] = sources
+			p.size += len(sources)
+		}
+	}
+
+	p.queued[s.Partition] = append(p.queued[s.Partition], s)
+	p.size++
+	return nil
+}
+
+// pack packs the queued sources of every partition. Until the last pack, the
+// last block of a partition, which may still grow, and the blocks with sources
+// of a reopened destination object, which is replaced as a whole, are queued
+// again instead of returned.
+func (p *Planner) pack(last bool) []Block {
+	var output []Block
+	p.size = 0
+	for _, name := range p.order {
+		sources := p.queued[name]
+		if len(sources) == 0 {
+			continue
+		}
+
+		var kept []Source
+		blocks := Pack(p.set, sources)
+		for i, block := range blocks {
+			if !last && (i == len(blocks)-1 || len(reopenedFrom(block)) > 0) {
+				kept = append(kept, block.Sources...)
+				continue
+			}
+			output = append(output, block)
+		}
+
+		p.queued[name] = kept
+		p.size += len(kept)
+	}
+	p.held = p.size
+
+	return output
+}
+
+// newSource reads the values the planner needs out of a source object
+func newSource(set models.ObjectSet, id []byte, row *models.SourceObject) Source {
+	_, key := row.PK()
+	lastModified := aws.TimeValue(row.LastModified)
+
+	return Source{
+		ID:           id,
+		Key:          aws.StringValue(row.Key),
+		ETag:         aws.StringValue(row.ETag),
+		Size:         aws.Int64Value(row.Size),
+		LastModified: lastModified,
+		Partition:    set.Partition(string(key), lastModified),
+	}
+}
+
+// SourceSize is the number of bytes a source adds to its destination object
+func SourceSize(set models.ObjectSet, s Source) int64 {
+	if set.Archive != "" {
+		return set.ArchiveEntrySize(s.Key, s.Size)
+	}
+	if set.Framing != framing.None {
+		return s.Size + framing.Overhead(set.Framing, set.FrameCRC)
+	}
+	return set.TargetSize(s.Key, s.Size) + int64(len(set.EncodedDelimiter()))
+}
+
+// Write adds a block's destination object to the object set and points its
+// sources to it. The destination object is named by the object set's key
+// template, a key that is already taken fails with ErrDuplicateKey. Reopened
+// sources remember the destination object they are moved from, so that it can
+// be expired once this one is written. It returns the bolt database id of the
+// destination object.
+func Write(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	block Block,
+	plannedAt time.Time,
+) ([]byte, error) {
+	// AppendRow takes the bucket's next sequence as the destination's id
+	key, err := set.DestinationKey(
+		keyFields(set, block, b.Sequence()+1, plannedAt),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dest := models.NewDestinationObject(set)
+	dest.Key = aws.String(key)
+	dest.Partition = block.Partition
+	dest.Size = aws.Int64(block.Size)
+	dest.State = models.StateNew
+
+	taken, err := boltdb.LookupID(b, dest)
+	if err != nil {
+		return nil, err
+	}
+	if taken != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateKey, key)
+	}
+
+	destID, err := boltdb.AppendRow(b, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range block.Sources {
+		source := models.NewSourceObject(set)
+		if err = boltdb.LookupRow(b, s.ID, source); err != nil {
+			return nil, err
+		}
+
+		current, err := source.Copy()
+		if err != nil {
+			return nil, err
+		}
+		if s.DestinationID != nil {
+			source.PreviousDestinationObjectID = s.DestinationID
+		}
+		source.DestinationObjectID = destID
+		source.State = models.StateInSync
+		if err = boltdb.UpdateRow(b, s.ID, source, current); err != nil {
+			return nil, err
+		}
+	}
+
+	return destID, nil
+}
+
+// keyFields builds the values available to the key template of a block's
+// destination object
+func keyFields(
+	set models.ObjectSet,
+	block Block,
+	sequence uint64,
+	plannedAt time.Time,
+) models.KeyFields {
+	h := sha256.New()
+	for _, s := range block.Sources {
+		io.WriteString(h, s.Key)
+		io.WriteString(h, "\n")
+		io.WriteString(h, s.ETag)
+		io.WriteString(h, "\n")
+	}
+	sum := h.Sum(nil)
+
+	id := uuid.Must(uuid.NewRandom())
+	if set.DeterministicKeys {
+		id = uuid.NewSHA1(uuid.NameSpaceURL, []byte(
+			"s3://"+path.Join(set.Bucket, set.Prefix)+"#"+hex.EncodeToString(sum),
+		))
+	}
+
+	var firstKey string
+	if len(block.Sources) > 0 {
+		firstKey = strings.TrimPrefix(block.Sources[0].Key, set.Prefix)
+	}
+
+	return models.KeyFields{
+		Partition: block.Partition,
+		Sequence:  sequence,
+		FirstKey:  firstKey,
+		PlannedAt: plannedAt.UTC().Format("20060102T150405Z"),
+		Hash:      hex.EncodeToString(sum),
+		Ext:       set.DestinationExt(models.KeyExt(firstKey)),
+		UUID:      id.String(),
+	}
+}
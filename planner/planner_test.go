@@ -0,0 +1,404 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+// testSet returns an object set whose table exists in a new bolt database
+func testSet(t *testing.T) (*models.ObjectSet, *bolt.DB, func()) {
+	f, err := ioutil.TempFile("", "s3fc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := models.NewObjectSet("b", "p/")
+	set.DestinationPath = "out/"
+	set.BlockSize = 8
+	if err = boltdb.EnsureTable(db, set); err != nil {
+		t.Fatal(err)
+	}
+
+	return set, db, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func appendSource(
+	t *testing.T,
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	key string,
+	state uint16,
+) []byte {
+	row := models.NewSourceObject(set)
+	row.Key = aws.String(set.Prefix + key)
+	row.ETag = aws.String(key)
+	row.Size = aws.Int64(4)
+	row.LastModified = aws.Time(epoch)
+	row.State = state
+
+	id, err := boltdb.AppendRow(b, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func setDestinationState(
+	t *testing.T,
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	id []byte,
+	state uint16,
+	verifiedAt *time.Time,
+) {
+	dest := models.NewDestinationObject(set)
+	if err := boltdb.LookupRow(b, id, dest); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := dest.Copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated.State = state
+	updated.VerifiedAt = verifiedAt
+	if err = boltdb.UpdateRow(b, id, updated, dest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// plan reads every source with a Planner, it returns the blocks closed while
+// reading and the blocks ready once every source is read
+func plan(t *testing.T, db *bolt.DB, set models.ObjectSet) ([]Block, []Block) {
+	var closed, ready []Block
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		p, err := New(b, set, models.StateNew)
+		if err != nil {
+			return err
+		}
+
+		for !p.Done() {
+			blocks, err := p.Next(b)
+			if err != nil {
+				return err
+			}
+			if p.size >= 2*batchSize {
+				t.Fatalf("Planner queued %d sources", p.size)
+			}
+			closed = append(closed, blocks...)
+		}
+
+		ready = p.Close(epoch)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return closed, ready
+}
+
+func TestPlanner(t *testing.T) {
+	tests := []struct {
+		name  string
+		topUp bool
+		want  [][]string
+	}{
+		{
+			name: "partitions",
+			want: [][]string{{"p/x/a", "p/x/c"}, {"p/y/b"}, {"p/e"}},
+		},
+		{
+			name:  "top up",
+			topUp: true,
+			want:  [][]string{{"p/x/d", "p/x/a"}, {"p/x/c"}, {"p/y/b"}, {"p/e"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, db, done := testSet(t)
+			defer done()
+			set.TopUp = tt.topUp
+			set.Partitioning = &models.Partitioning{
+				By:      models.PartitionByKeyRegex,
+				Pattern: `^(\w+)/`,
+			}
+			if err := set.Partitioning.Compile(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(set.Name())
+				s := source(set.Prefix+"x/d", 4, "x")
+				s.ID = appendSource(t, b, *set, "x/d", models.StateNew)
+				destID, err := Write(b, *set, Block{
+					Partition: "x",
+					Sources:   []Source{s},
+					Size:      4,
+				}, epoch)
+				if err != nil {
+					return err
+				}
+				setDestinationState(t, b, *set, destID, models.StateInSync, nil)
+
+				appendSource(t, b, *set, "x/a", models.StateNew)
+				appendSource(t, b, *set, "y/b", models.StateNew)
+				appendSource(t, b, *set, "x/c", models.StateNew)
+				appendSource(t, b, *set, "e", models.StateNew)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			closed, ready := plan(t, db, *set)
+			if len(closed) > 0 {
+				t.Errorf("Next() = %v, want no blocks", blockKeys(closed))
+			}
+			if got := blockKeys(ready); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Close() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerBatches(t *testing.T) {
+	set, db, done := testSet(t)
+	defer done()
+	set.Partitioning = &models.Partitioning{
+		By:      models.PartitionByKeyRegex,
+		Pattern: `^(\w+)/`,
+	}
+	if err := set.Partitioning.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 3*batchSize + 1
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("x/%05d", i)
+			if i%3 == 0 {
+				key = fmt.Sprintf("y/%05d", i)
+			}
+			appendSource(t, b, *set, key, models.StateNew)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	closed, ready := plan(t, db, *set)
+	if len(closed) == 0 {
+		t.Fatal("Next() closed no blocks")
+	}
+
+	seen := make(map[string]bool)
+	last := make(map[string]int)
+	blocks := append(closed, ready...)
+	for i, block := range blocks {
+		last[block.Partition] = i
+		for _, s := range block.Sources {
+			if seen[s.Key] {
+				t.Errorf("source %s planned twice", s.Key)
+			}
+			seen[s.Key] = true
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("planned %d sources, want %d", len(seen), n)
+	}
+	for i, block := range blocks {
+		if last[block.Partition] != i && len(block.Sources) != 2 {
+			t.Errorf("block %d of %q has %d sources, want 2", i, block.Partition, len(block.Sources))
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		err      error
+	}{
+		{name: "default template"},
+		{name: "sequence", template: "part-{{.Sequence}}"},
+		{name: "taken key", template: "part", err: ErrDuplicateKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, db, done := testSet(t)
+			defer done()
+			set.KeyTemplate = tt.template
+
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(set.Name())
+				var blocks []Block
+				for _, key := range []string{"a", "b"} {
+					s := source(set.Prefix+key, 4, "")
+					s.ID = appendSource(t, b, *set, key, models.StateNew)
+					blocks = append(blocks, Block{Sources: []Source{s}, Size: 4})
+				}
+
+				if _, err := Write(b, *set, blocks[0], epoch); err != nil {
+					return err
+				}
+				destID, err := Write(b, *set, blocks[1], epoch)
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Write() error = %v, want %v", err, tt.err)
+				}
+				if err != nil {
+					return nil
+				}
+
+				dest := models.NewDestinationObject(*set)
+				if err = boltdb.LookupRow(b, destID, dest); err != nil {
+					return err
+				}
+				if found, err := boltdb.LookupID(b, dest); err != nil ||
+					!reflect.DeepEqual(found, destID) {
+					t.Errorf("LookupID(%s) = %v, want %v", aws.StringValue(dest.Key), found, destID)
+				}
+
+				row := models.NewSourceObject(*set)
+				if err = boltdb.LookupRow(b, blocks[1].Sources[0].ID, row); err != nil {
+					return err
+				}
+				if row.State != models.StateInSync ||
+					!reflect.DeepEqual(row.DestinationObjectID, destID) {
+					t.Errorf("source = %v %v, want IN_SYNC %v", row.State, row.DestinationObjectID, destID)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name     string
+		replaced uint16
+		want     uint16
+	}{
+		{name: "replacement not written", replaced: models.StateNew, want: models.StateInSync},
+		{name: "replacement written", replaced: models.StateInSync, want: models.StateExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, db, done := testSet(t)
+			defer done()
+
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(set.Name())
+				s := source(set.Prefix+"a", 4, "")
+				s.ID = appendSource(t, b, *set, "a", models.StateNew)
+				previous, err := Write(b, *set, Block{Sources: []Source{s}, Size: 4}, epoch)
+				if err != nil {
+					return err
+				}
+				setDestinationState(t, b, *set, previous, models.StateInSync, nil)
+
+				sources, err := DestinationSources(b, *set, previous)
+				if err != nil {
+					return err
+				}
+				replacing, err := Write(b, *set, Block{Sources: sources, Size: 4}, epoch)
+				if err != nil {
+					return err
+				}
+				setDestinationState(t, b, *set, replacing, tt.replaced, nil)
+
+				if err = Expire(b, *set, replacing); err != nil {
+					return err
+				}
+
+				dest := models.NewDestinationObject(*set)
+				if err = boltdb.LookupRow(b, previous, dest); err != nil {
+					return err
+				}
+				if dest.State != tt.want {
+					t.Errorf("previous destination = %v, want %v",
+						models.State(dest.State), models.State(tt.want))
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeletable(t *testing.T) {
+	now := epoch.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		delete     bool
+		retention  time.Duration
+		verifiedAt *time.Time
+		want       int
+	}{
+		{name: "keeps sources", verifiedAt: aws.Time(epoch)},
+		{name: "not verified", delete: true},
+		{name: "verified", delete: true, verifiedAt: aws.Time(epoch), want: 1},
+		{
+			name:       "retained",
+			delete:     true,
+			retention:  2 * time.Hour,
+			verifiedAt: aws.Time(epoch),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, db, done := testSet(t)
+			defer done()
+			set.DeleteSources = tt.delete
+			set.Retention = tt.retention
+
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(set.Name())
+				s := source(set.Prefix+"a", 4, "")
+				s.ID = appendSource(t, b, *set, "a", models.StateNew)
+				destID, err := Write(b, *set, Block{Sources: []Source{s}, Size: 4}, epoch)
+				if err != nil {
+					return err
+				}
+				setDestinationState(t, b, *set, destID, models.StateInSync, tt.verifiedAt)
+
+				ids, _, err := Deletable(b, *set, now, 0)
+				if err != nil {
+					return err
+				}
+				if len(ids) != tt.want {
+					t.Errorf("Deletable() = %d sources, want %d", len(ids), tt.want)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
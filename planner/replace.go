@@ -0,0 +1,300 @@
+package planner
+
+import (
+	"encoding/binary"
+	"s3fc/boltdb"
+	"s3fc/models"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	destinationStateIndex    = "idx_destination_state"
+	destinationIndex         = "idx_destination"
+	previousDestinationIndex = "idx_previous_destination"
+)
+
+// Destination is a written destination object whose sources can be planned
+// again into a replacing destination object
+type Destination struct {
+	ID        []byte
+	Partition string
+	Size      int64
+}
+
+// Undersized queries for the IN_SYNC destination objects of an object set that
+// are smaller than maxSize. A destination object that already has a planned
+// replacement is left out. Destination objects of an object set that deletes
+// source objects are never replaced, their sources may be gone.
+func Undersized(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	maxSize int64,
+) ([]Destination, error) {
+	var output []Destination
+	if set.DeleteSources {
+		return output, nil
+	}
+
+	err := scan(
+		b,
+		[]byte(destinationStateIndex),
+		boltdb.Uint16tol(models.StateInSync),
+		func(id []byte) error {
+			dest := models.NewDestinationObject(set)
+			if err := boltdb.LookupRow(b, id, dest); err != nil {
+				return err
+			}
+
+			size := aws.Int64Value(dest.Size)
+			if size >= maxSize {
+				return nil
+			}
+
+			replaced, err := boltdb.PrefixQuery(
+				b, []byte(previousDestinationIndex), id, 1, nil,
+			)
+			if err != nil || len(replaced) > 0 {
+				return err
+			}
+
+			output = append(output, Destination{
+				ID:        id,
+				Partition: dest.Partition,
+				Size:      size,
+			})
+			return nil
+		},
+	)
+
+	return output, err
+}
+
+// Reopen finds the most recent under-filled destination object of every
+// partition, so that its sources can be packed again together with the new
+// sources of the partition. It returns their bolt database ids by partition.
+func Reopen(b *bolt.Bucket, set models.ObjectSet) (map[string][]byte, error) {
+	dests, err := Undersized(b, set, set.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string][]byte)
+	for _, d := range dests {
+		// ids are little endian, the index is not in sequence order
+		current := latest[d.Partition]
+		if current == nil ||
+			binary.LittleEndian.Uint64(d.ID) > binary.LittleEndian.Uint64(current) {
+			latest[d.Partition] = d.ID
+		}
+	}
+
+	return latest, nil
+}
+
+// DestinationSources reads the IN_SYNC source objects of a destination object
+// so that they can be planned into a replacing destination object
+func DestinationSources(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	destID []byte,
+) ([]Source, error) {
+	var sources []Source
+
+	err := scan(b, []byte(destinationIndex), destID, func(id []byte) error {
+		row := models.NewSourceObject(set)
+		if err := boltdb.LookupRow(b, id, row); err != nil {
+			return err
+		}
+		if row.State != models.StateInSync {
+			return nil
+		}
+
+		source := newSource(set, id, row)
+		source.DestinationID = destID
+		sources = append(sources, source)
+		return nil
+	})
+
+	return sources, err
+}
+
+// Expire flags the destination objects that the sources of a written
+// destination object were previously written to as EXPIRED. A previous
+// destination object is only expired once none of its sources remain and
+// every destination object its sources were moved to is IN_SYNC.
+func Expire(
+	b *bolt.Bucket,
+	set models.ObjectSet,
+	destID []byte,
+) error {
+	var previous [][]byte
+	seen := make(map[string]bool)
+
+	err := scan(b, []byte(destinationIndex), destID, func(id []byte) error {
+		row := models.NewSourceObject(set)
+		if err := boltdb.LookupRow(b, id, row); err != nil {
+			return err
+		}
+
+		p := row.PreviousDestinationObjectID
+		if p != nil && !seen[string(p)] {
+			seen[string(p)] = true
+			previous = append(previous, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range previous {
+		if err = expire(b, set, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func expire(b *bolt.Bucket, set models.ObjectSet, destID []byte) error {
+	var remaining bool
+	err := scan(b, []byte(destinationIndex), destID, func(id []byte) error {
+		row := models.NewSourceObject(set)
+		if err := boltdb.LookupRow(b, id, row); err != nil {
+			return err
+		}
+
+		remaining = remaining || row.State == models.StateInSync
+		return nil
+	})
+	if err != nil || remaining {
+		return err
+	}
+
+	var moved []*models.SourceObject
+	var movedIDs [][]byte
+	var pending bool
+	err = scan(b, []byte(previousDestinationIndex), destID, func(id []byte) error {
+		row := models.NewSourceObject(set)
+		if err := boltdb.LookupRow(b, id, row); err != nil {
+			return err
+		}
+
+		dest := models.NewDestinationObject(set)
+		if err := boltdb.LookupRow(b, row.DestinationObjectID, dest); err != nil {
+			return err
+		}
+
+		pending = pending || dest.State != models.StateInSync
+		moved = append(moved, row)
+		movedIDs = append(movedIDs, id)
+		return nil
+	})
+	if err != nil || pending {
+		return err
+	}
+
+	dest := models.NewDestinationObject(set)
+	if err = boltdb.LookupRow(b, destID, dest); err != nil {
+		return err
+	}
+	if dest.State == models.StateInSync {
+		expired, err := dest.Copy()
+		if err != nil {
+			return err
+		}
+		expired.State = models.StateExpired
+		if err = boltdb.UpdateRow(b, destID, expired, dest); err != nil {
+			return err
+		}
+	}
+
+	for i, row := range moved {
+		cleared, err := row.Copy()
+		if err != nil {
+			return err
+		}
+		cleared.PreviousDestinationObjectID = nil
+		if err = boltdb.UpdateRow(b, movedIDs[i], cleared, row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Merges drops the blocks that would only rewrite every source of a single
+// destination object, so that compacting does not replace a destination
+// object with an identical one.
+func Merges(blocks []Block) []Block {
+	counts := make(map[string]int)
+	for _, block := range blocks {
+		for _, s := range block.Sources {
+			counts[string(s.DestinationID)]++
+		}
+	}
+
+	output := blocks[:0]
+	for _, block := range blocks {
+		from := reopenedFrom(block)
+		if len(from) == 1 &&
+			len(block.Sources) == counts[string(from[0])] {
+			continue
+		}
+		output = append(output, block)
+	}
+
+	return output
+}
+
+// reopenedFrom returns the distinct destination objects that a block's
+// sources were reopened from
+func reopenedFrom(block Block) [][]byte {
+	var output [][]byte
+	seen := make(map[string]bool)
+	for _, s := range block.Sources {
+		if s.DestinationID != nil && !seen[string(s.DestinationID)] {
+			seen[string(s.DestinationID)] = true
+			output = append(output, s.DestinationID)
+		}
+	}
+
+	return output
+}
+
+// scan calls f with every bolt database id in an index under prefix
+func scan(
+	b *bolt.Bucket,
+	index []byte,
+	prefix []byte,
+	f func(id []byte) error,
+) error {
+	limit := 2048
+
+	var exclusiveStart []byte
+	for running := true; running; {
+		ids, err := boltdb.PrefixQuery(
+			b, index, prefix, limit, exclusiveStart,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err = f(id); err != nil {
+				return err
+			}
+		}
+
+		if len(ids) < limit {
+			running = false
+			continue
+		}
+
+		exclusiveStart = boltdb.MakeIndex(prefix, ids[len(ids)-1])
+	}
+
+	return nil
+}
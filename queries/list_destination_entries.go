@@ -0,0 +1,127 @@
+package queries
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"s3fc/base"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+// ListDestinationEntries is a query that returns a paginated list of the
+// entries of a destination object, the source objects planned into it with the
+// name and last modified time they have in an archive.
+type ListDestinationEntries struct {
+	Bucket         string  `json:"bucket"`
+	Prefix         string  `json:"prefix"`
+	ID             string  `json:"id"`
+	Limit          int     `json:"limit"`
+	ExclusiveStart *string `json:"exclusive_start"`
+
+	db *bolt.DB
+}
+
+// ListDestinationEntriesItem a source object of the destination object
+type ListDestinationEntriesItem struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	State        string    `json:"state"`
+}
+
+// ListDestinationEntriesOutput the ouput of the query
+type ListDestinationEntriesOutput struct {
+	Key      string                       `json:"key"`
+	Archive  string                       `json:"archive,omitempty"`
+	Items    []ListDestinationEntriesItem `json:"items"`
+	Length   int                          `json:"length"`
+	NextPage *string                      `json:"next_page"`
+}
+
+// Invoke executes the ListDestinationEntries query
+func (l ListDestinationEntries) Invoke(ctx context.Context, w io.Writer) error {
+	id, err := base64.RawURLEncoding.DecodeString(l.ID)
+	if err != nil {
+		return err
+	}
+
+	return l.db.View(func(tx *bolt.Tx) error {
+		var exclusiveStart []byte
+
+		set := models.NewObjectSet(l.Bucket, l.Prefix)
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+
+		dest := models.NewDestinationObject(*set)
+		if err = boltdb.LookupRow(b, id, dest); err != nil {
+			return err
+		}
+
+		if l.ExclusiveStart != nil {
+			exclusiveStart, err = base64.RawURLEncoding.DecodeString(*l.ExclusiveStart)
+			if err != nil {
+				return err
+			}
+			exclusiveStart = boltdb.MakeIndex(id, exclusiveStart)
+		}
+		ids, err := boltdb.PrefixQuery(
+			b, []byte("idx_destination"), id, l.Limit, exclusiveStart,
+		)
+		if err != nil {
+			return err
+		}
+
+		var lastID []byte
+		items := make([]ListDestinationEntriesItem, 0, len(ids))
+		for _, sID := range ids {
+			source := models.NewSourceObject(*set)
+			if err = boltdb.LookupRow(b, sID, source); err != nil {
+				return err
+			}
+
+			key := aws.StringValue(source.Key)
+			items = append(items, ListDestinationEntriesItem{
+				ID:           base64.RawURLEncoding.EncodeToString(sID),
+				Name:         set.EntryName(key),
+				Key:          key,
+				Size:         aws.Int64Value(source.Size),
+				LastModified: aws.TimeValue(source.LastModified),
+				State:        models.State(source.State).String(),
+			})
+
+			lastID = sID
+		}
+
+		output := &ListDestinationEntriesOutput{
+			Key:     aws.StringValue(dest.Key),
+			Archive: set.Archive,
+			Items:   items,
+			Length:  len(items),
+		}
+
+		if lastID != nil && l.Limit > 0 && output.Length >= l.Limit {
+			output.NextPage = aws.String(base64.RawURLEncoding.EncodeToString(lastID))
+		}
+
+		return json.NewEncoder(w).Encode(output)
+	})
+}
+
+// Dependencies initializes a new command instance for invocation
+func (l *ListDestinationEntries) Dependencies(
+	c base.Container,
+) (err error) {
+	l.db, err = c.DB()
+
+	return err
+}
@@ -0,0 +1,133 @@
+package queries
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+// testSet stores an object set in a new bolt database
+func testSet(t *testing.T) (*models.ObjectSet, *bolt.DB, func()) {
+	f, err := ioutil.TempFile("", "s3fc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := models.NewObjectSet("b", "p/")
+	set.DestinationPath = "out/"
+	set.BlockSize = 8
+	if err = boltdb.EnsureTable(db, set); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		values, err := set.Marshal()
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if v == nil {
+				continue
+			}
+			if err = b.Put(set.Schema()[k], v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return set, db, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestListDestinationEntries(t *testing.T) {
+	set, db, done := testSet(t)
+	defer done()
+
+	var destID []byte
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(set.Name())
+		dest := models.NewDestinationObject(*set)
+		dest.Key = aws.String("out/d")
+		dest.State = models.StateNew
+
+		var err error
+		if destID, err = boltdb.AppendRow(b, dest); err != nil {
+			return err
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			row := models.NewSourceObject(*set)
+			row.Key = aws.String(set.Prefix + key)
+			row.ETag = aws.String(key)
+			row.Size = aws.Int64(4)
+			row.LastModified = aws.Time(time.Now())
+			row.State = models.StateInSync
+			row.DestinationObjectID = destID
+			if _, err = boltdb.AppendRow(b, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		limit    int
+		length   int
+		nextPage bool
+	}{
+		{name: "unlimited", limit: 0, length: 3},
+		{name: "first page", limit: 2, length: 2, nextPage: true},
+		{name: "limit of every entry", limit: 3, length: 3, nextPage: true},
+		{name: "beyond every entry", limit: 4, length: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			query := ListDestinationEntries{
+				Bucket: set.Bucket,
+				Prefix: set.Prefix,
+				ID:     base64.RawURLEncoding.EncodeToString(destID),
+				Limit:  tt.limit,
+				db:     db,
+			}
+			if err := query.Invoke(context.Background(), &buf); err != nil {
+				t.Fatal(err)
+			}
+
+			var output ListDestinationEntriesOutput
+			if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+				t.Fatal(err)
+			}
+			if output.Length != tt.length || (output.NextPage != nil) != tt.nextPage {
+				t.Errorf(
+					"ListDestinationEntries() length = %d, next page = %v, want %d, %v",
+					output.Length, output.NextPage, tt.length, tt.nextPage,
+				)
+			}
+		})
+	}
+}
@@ -33,6 +33,9 @@ type ListObjectByStateItem struct {
 	ID    string `json:"id"`
 	State string `json:"state"`
 	Size  int64  `json:"size"`
+	// WrittenSize the number of bytes written to a destination object, when it
+	// was written
+	WrittenSize *int64 `json:"written_size,omitempty"`
 }
 
 // ListObjectByStateOutput the ouput of the query
@@ -80,6 +83,7 @@ func (l ListObjectByState) Invoke(ctx context.Context, w io.Writer) error {
 		for _, id := range ids {
 			var row boltdb.Row
 			var object *models.Object
+			var writtenSize *int64
 
 			if l.Type == "source" {
 				row = models.NewSourceObject(*set)
@@ -91,6 +95,9 @@ func (l ListObjectByState) Invoke(ctx context.Context, w io.Writer) error {
 			if err = boltdb.LookupRow(b, id, row); err != nil {
 				return err
 			}
+			if dest, ok := row.(*models.DestinationObject); ok {
+				writtenSize = dest.WrittenSize
+			}
 
 			items = append(items, ListObjectByStateItem{
 				ID: base64.RawURLEncoding.EncodeToString(id),
@@ -98,6 +105,8 @@ func (l ListObjectByState) Invoke(ctx context.Context, w io.Writer) error {
 				// Key:    aws.StringValue(object.Key),
 				State: models.State(object.State).String(),
 				Size:  aws.Int64Value(object.Size),
+
+				WrittenSize: writtenSize,
 			})
 
 			lastID = id
@@ -0,0 +1,250 @@
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"s3fc/base"
+	"s3fc/boltdb"
+	"s3fc/models"
+	"s3fc/planner"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/boltdb/bolt"
+)
+
+// PreviewPlan is a query that returns what PlanNewObjects would plan for the
+// NEW source objects of an ObjectSet without changing any state. The planning
+// options of the object set can be overridden to preview a change before it is
+// made with PutObjectSet. Destinations are paginated by Limit, or all of them
+// are written as JSON to OutputURL (s3:// or file://).
+type PreviewPlan struct {
+	Bucket         string  `json:"bucket"`
+	Prefix         string  `json:"prefix"`
+	Limit          int     `json:"limit"`
+	ExclusiveStart *string `json:"exclusive_start"`
+	OutputURL      *string `json:"output_url,omitempty"`
+
+	BlockSize    *int64  `json:"block_size,omitempty"`
+	Packing      *string `json:"packing,omitempty"`
+	SortBy       *string `json:"sort_by,omitempty"`
+	MaxSources   *int64  `json:"max_sources_per_destination,omitempty"`
+	MinBlockSize *int64  `json:"min_block_size,omitempty"`
+
+	db        *bolt.DB
+	inventory base.InventoryManager
+}
+
+// PreviewPlanItem a destination object that would be planned
+type PreviewPlanItem struct {
+	Index     int      `json:"index"`
+	Partition string   `json:"partition,omitempty"`
+	Size      int64    `json:"size"`
+	Sources   []string `json:"sources"`
+}
+
+// PreviewPlanPartition totals of the destination objects of a partition
+type PreviewPlanPartition struct {
+	Destinations int   `json:"destinations"`
+	Sources      int   `json:"sources"`
+	Size         int64 `json:"size"`
+}
+
+// PreviewPlanOutput the ouput of the query, Pending counts the NEW source
+// objects that would stay NEW because of the object set's MinBlockSize
+type PreviewPlanOutput struct {
+	Destinations int                             `json:"destinations"`
+	Sources      int                             `json:"sources"`
+	Pending      int                             `json:"pending"`
+	Size         int64                           `json:"size"`
+	Histogram    map[string]int                  `json:"histogram"`
+	Partitions   map[string]PreviewPlanPartition `json:"partitions"`
+
+	Items    []PreviewPlanItem `json:"items"`
+	Length   int               `json:"length"`
+	NextPage *string           `json:"next_page"`
+	URL      *string           `json:"url,omitempty"`
+}
+
+// histogramBuckets labels destination sizes relative to the block size
+var histogramBuckets = []struct {
+	label string
+	below float64
+}{
+	{"0-25%", 0.25},
+	{"25-50%", 0.5},
+	{"50-75%", 0.75},
+	{"75-100%", 1},
+	{"100-150%", 1.5},
+	{"150%+", 0},
+}
+
+// Invoke executes the PreviewPlan query
+func (p PreviewPlan) Invoke(ctx context.Context, w io.Writer) error {
+	var output PreviewPlanOutput
+	var blocks []planner.Block
+	var set *models.ObjectSet
+
+	if err := p.db.View(func(tx *bolt.Tx) error {
+		set = models.NewObjectSet(p.Bucket, p.Prefix)
+		b, err := boltdb.LookupTable(tx, set)
+		if err != nil {
+			return err
+		}
+		if err = p.override(set); err != nil {
+			return err
+		}
+
+		plan, err := planner.New(b, *set, models.StateNew)
+		if err != nil {
+			return err
+		}
+		for !plan.Done() {
+			closed, err := plan.Next(b)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, closed...)
+		}
+
+		blocks = append(blocks, plan.Close(time.Now())...)
+		output.Pending = plan.Read()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	output.Histogram = make(map[string]int)
+	output.Partitions = make(map[string]PreviewPlanPartition)
+	for _, block := range blocks {
+		output.Destinations++
+		output.Sources += len(block.Sources)
+		output.Size += block.Size
+		output.Histogram[histogramBucket(set.BlockSize, block.Size)]++
+
+		partition := output.Partitions[block.Partition]
+		partition.Destinations++
+		partition.Sources += len(block.Sources)
+		partition.Size += block.Size
+		output.Partitions[block.Partition] = partition
+
+		for _, s := range block.Sources {
+			if s.DestinationID == nil {
+				output.Pending--
+			}
+		}
+	}
+
+	if p.OutputURL != nil {
+		output.Items = previewItems(blocks, 0, len(blocks))
+		output.Length = len(output.Items)
+		if err := p.writeOutput(ctx, output); err != nil {
+			return err
+		}
+
+		output.Items, output.Length = nil, 0
+		output.URL = p.OutputURL
+		return json.NewEncoder(w).Encode(output)
+	}
+
+	start := 0
+	if p.ExclusiveStart != nil {
+		last, err := strconv.Atoi(*p.ExclusiveStart)
+		if err != nil {
+			return err
+		}
+		start = last + 1
+	}
+
+	end := len(blocks)
+	if p.Limit > 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+	output.Items = previewItems(blocks, start, end)
+	output.Length = len(output.Items)
+	if end < len(blocks) {
+		output.NextPage = aws.String(strconv.Itoa(end - 1))
+	}
+
+	return json.NewEncoder(w).Encode(output)
+}
+
+// Dependencies initializes a new command instance for invocation
+func (p *PreviewPlan) Dependencies(
+	c base.Container,
+) (err error) {
+	p.db, err = c.DB()
+	p.inventory = c.InventoryManager()
+
+	return err
+}
+
+// override replaces the object set's planning options with the ones passed to
+// the query
+func (p *PreviewPlan) override(set *models.ObjectSet) error {
+	if p.BlockSize != nil {
+		set.BlockSize = *p.BlockSize
+	}
+	if p.Packing != nil {
+		set.Packing = *p.Packing
+	}
+	if p.SortBy != nil {
+		set.SortBy = *p.SortBy
+	}
+	if p.MaxSources != nil {
+		set.MaxSources = *p.MaxSources
+	}
+	if p.MinBlockSize != nil {
+		set.MinBlockSize = *p.MinBlockSize
+	}
+
+	return set.ValidatePlanning()
+}
+
+func (p *PreviewPlan) writeOutput(
+	ctx context.Context,
+	output PreviewPlanOutput,
+) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(json.NewEncoder(w).Encode(output))
+	}()
+
+	return p.inventory.WriteFrom(ctx, r, *p.OutputURL)
+}
+
+func previewItems(blocks []planner.Block, start int, end int) []PreviewPlanItem {
+	if start >= end {
+		return []PreviewPlanItem{}
+	}
+
+	items := make([]PreviewPlanItem, 0, end-start)
+	for i := start; i < end; i++ {
+		keys := make([]string, 0, len(blocks[i].Sources))
+		for _, s := range blocks[i].Sources {
+			keys = append(keys, s.Key)
+		}
+
+		items = append(items, PreviewPlanItem{
+			Index:     i,
+			Partition: blocks[i].Partition,
+			Size:      blocks[i].Size,
+			Sources:   keys,
+		})
+	}
+
+	return items
+}
+
+func histogramBucket(blockSize int64, size int64) string {
+	ratio := float64(size) / float64(blockSize)
+	for _, b := range histogramBuckets[:len(histogramBuckets)-1] {
+		if ratio < b.below {
+			return b.label
+		}
+	}
+
+	return histogramBuckets[len(histogramBuckets)-1].label
+}
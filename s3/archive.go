@@ -0,0 +1,97 @@
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"s3fc/models"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// archiveWriter adds entries to a tar or zip file
+type archiveWriter interface {
+	create(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+// writeArchive writes every source object as it is stored to an entry of a tar
+// or zip file, named by its key relative to the object set's prefix and with
+// its last modified time
+func writeArchive(
+	ctx context.Context,
+	client s3iface.S3API,
+	w io.Writer,
+	set models.ObjectSet,
+	sourceObjects []models.SourceObject,
+) error {
+	var aw archiveWriter
+	if set.Archive == models.ArchiveZip {
+		aw = zipWriter{zip.NewWriter(w)}
+	} else {
+		aw = tarWriter{tar.NewWriter(w)}
+	}
+
+	for _, source := range sourceObjects {
+		output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(source.Parent.Bucket),
+			Key:       source.Key,
+			VersionId: source.VersionID,
+		})
+		if err != nil {
+			return err
+		}
+
+		size := aws.Int64Value(output.ContentLength)
+		if output.ContentLength == nil {
+			size = aws.Int64Value(source.Size)
+		}
+		modified := aws.TimeValue(source.LastModified)
+		if modified.IsZero() {
+			modified = aws.TimeValue(output.LastModified)
+		}
+
+		entry, err := aw.create(set.EntryName(aws.StringValue(source.Key)), size, modified)
+		if err == nil {
+			_, err = io.Copy(entry, output.Body)
+		}
+		output.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return aw.Close()
+}
+
+type tarWriter struct {
+	*tar.Writer
+}
+
+func (t tarWriter) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	err := t.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modified,
+	})
+
+	return t.Writer, err
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (z zipWriter) create(name string, size int64, modified time.Time) (io.Writer, error) {
+	return z.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+}
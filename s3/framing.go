@@ -0,0 +1,48 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"s3fc/framing"
+	"s3fc/models"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// writeRecords writes every source object as it is stored to a record of the
+// object set's framing format
+func writeRecords(
+	ctx context.Context,
+	client s3iface.S3API,
+	w io.Writer,
+	set models.ObjectSet,
+	sourceObjects []models.SourceObject,
+) error {
+	fw := framing.NewWriter(w, set.Framing, set.FrameCRC)
+
+	for _, source := range sourceObjects {
+		output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(source.Parent.Bucket),
+			Key:       source.Key,
+			VersionId: source.VersionID,
+		})
+		if err != nil {
+			return err
+		}
+
+		size := aws.Int64Value(output.ContentLength)
+		if output.ContentLength == nil {
+			size = aws.Int64Value(source.Size)
+		}
+
+		err = fw.Copy(output.Body, size)
+		output.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
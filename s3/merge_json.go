@@ -0,0 +1,248 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"s3fc/codec"
+	"s3fc/models"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// utf8BOM the byte order mark some tools write at the start of UTF-8 files
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// jsonBufferSize the number of bytes of a source object's values held back
+// before they are written, a source object that fails to parse before then is
+// quarantined without any of its values written
+const jsonBufferSize = 4 << 20
+
+// jsonMerger writes JSON source objects as the elements of a single top-level
+// array or as JSON Lines. Every value is compacted so it takes a single line.
+type jsonMerger struct {
+	mode string
+	n    int
+	buf  bytes.Buffer
+	// flushed is set once values of the current source object were written
+	flushed bool
+}
+
+// open starts the destination object's array
+func (j *jsonMerger) open(cw *codecWriter) error {
+	if j.mode != models.JSONArray {
+		return nil
+	}
+
+	_, err := cw.Write([]byte("["))
+	return err
+}
+
+// close ends the destination object's array
+func (j *jsonMerger) close(cw *codecWriter) error {
+	if j.mode != models.JSONArray {
+		return nil
+	}
+
+	end := "]\n"
+	if j.n > 0 {
+		end = "\n" + end
+	}
+	_, err := cw.Write([]byte(end))
+	return err
+}
+
+// copySource decompresses and parses a JSON source object and streams its
+// values. Nothing is copied from a source object that does not parse within
+// its first jsonBufferSize bytes of values, the parse error is returned as the
+// reason to quarantine it. A parse error after values were written fails the
+// copy.
+func (j *jsonMerger) copySource(
+	cw *codecWriter,
+	body io.Reader,
+	source models.SourceObject,
+) (error, error) {
+	dr, err := decompress(body, cw.stripBOM)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	j.buf.Reset()
+	j.flushed = false
+	n := j.n
+
+	var reason error
+	if j.mode == models.JSONArray {
+		reason, err = j.elements(cw, json.NewDecoder(dr), &n)
+	} else {
+		reason, err = j.lines(cw, bufio.NewReader(dr))
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case reason != nil && j.flushed:
+		return nil, fmt.Errorf(
+			"%w: %s: %v", ErrInvalidJSON, aws.StringValue(source.Key), reason,
+		)
+	case reason != nil:
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, reason), nil
+	}
+
+	j.n = n
+	_, err = cw.Write(j.buf.Bytes())
+	return nil, err
+}
+
+// elements adds every top-level value of a source object as an element of the
+// array, the elements of a top-level array are decoded and added one by one.
+// It returns the parse error of a source object that is not valid JSON.
+func (j *jsonMerger) elements(
+	cw *codecWriter,
+	dec *json.Decoder,
+	n *int,
+) (error, error) {
+	for dec.More() {
+		if peek(dec) != '[' {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return parseError(err)
+			}
+			if err := j.element(cw, value, n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if _, err := dec.Token(); err != nil {
+			return parseError(err)
+		}
+		for dec.More() {
+			var element json.RawMessage
+			if err := dec.Decode(&element); err != nil {
+				return parseError(err)
+			}
+			if err := j.element(cw, element, n); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err == io.EOF {
+			return io.ErrUnexpectedEOF, nil
+		} else if err != nil {
+			return parseError(err)
+		}
+	}
+
+	// a stray closing delimiter stops More, Token reports it
+	if _, err := dec.Token(); err != io.EOF {
+		return parseError(err)
+	}
+
+	return nil, nil
+}
+
+func (j *jsonMerger) element(
+	cw *codecWriter,
+	value json.RawMessage,
+	n *int,
+) error {
+	if *n > 0 {
+		j.buf.WriteByte(',')
+	}
+	j.buf.WriteByte('\n')
+	*n++
+
+	if err := json.Compact(&j.buf, value); err != nil {
+		return err
+	}
+	return j.flush(cw)
+}
+
+// lines adds every non-empty line of a source object as a compact JSON value.
+// It returns the parse error of a line that is not valid JSON.
+func (j *jsonMerger) lines(cw *codecWriter, r *bufio.Reader) (error, error) {
+	for i := 1; ; i++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		eof := err == io.EOF
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if err = json.Compact(&j.buf, line); err != nil {
+				return fmt.Errorf("line %d: %v", i, err), nil
+			}
+			j.buf.WriteByte('\n')
+			if err = j.flush(cw); err != nil {
+				return nil, err
+			}
+		}
+
+		if eof {
+			return nil, nil
+		}
+	}
+}
+
+// flush writes the held back values once they reach jsonBufferSize
+func (j *jsonMerger) flush(cw *codecWriter) error {
+	if j.buf.Len() < jsonBufferSize {
+		return nil
+	}
+
+	j.flushed = true
+	_, err := cw.Write(j.buf.Bytes())
+	j.buf.Reset()
+	return err
+}
+
+// peek returns the first byte of the next value of a decoder, More has
+// already read it into the decoder's buffer
+func peek(dec *json.Decoder) byte {
+	r := bufio.NewReader(dec.Buffered())
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c
+		}
+	}
+}
+
+// parseError splits the errors of a decoder into the parse error of invalid
+// JSON and any other error reading the source object
+func parseError(err error) (error, error) {
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) || err == io.ErrUnexpectedEOF {
+		return err, nil
+	}
+
+	return nil, err
+}
+
+// decompress returns a reader of a source object's body decompressed with the
+// codec its first bytes match, optionally without a leading byte order mark
+func decompress(body io.Reader, stripBOM bool) (io.ReadCloser, error) {
+	sourceCodec, br, err := codec.Peek(body)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := codec.NewReader(sourceCodec, br)
+	if err != nil || !stripBOM {
+		return dr, err
+	}
+
+	r := bufio.NewReader(dr)
+	if bom, _ := r.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		r.Discard(len(utf8BOM))
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, dr}, nil
+}
@@ -1,9 +1,19 @@
 package s3
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"path"
+	"s3fc/codec"
+	"s3fc/framing"
+	"s3fc/lineage"
 	"s3fc/models"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -11,60 +21,536 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+var (
+	// ErrHeaderMismatch tells a caller that the CSV header of a source object
+	// does not match the header of the first source object of its destination
+	ErrHeaderMismatch = errors.New("CSV header does not match the destination object's header")
+	// ErrInvalidJSON tells a caller that a source object could not be parsed
+	// as per the object set's JSON mode
+	ErrInvalidJSON = errors.New("source object is not valid JSON")
+)
+
+// MergeOutput what MergeObjects wrote to a destination object
+type MergeOutput struct {
+	// Size the number of bytes written and MD5 their checksum, PartMD5s the
+	// checksums of the parts they were uploaded in
+	Size     int64
+	MD5      []byte
+	PartMD5s [][]byte
+	// Quarantined the source objects left out of the destination object
+	Quarantined []SourceError
+}
+
+// SourceError a source object that was left out of its destination object and
+// the reason why
+type SourceError struct {
+	Index int
+	Err   error
+}
+
 // MergeObjects writes the provided list of SourceObjects to an S3 Object as per
-// the configuration of the passed DestinationObject.
+// the configuration of the passed DestinationObject, compressed with the
+// object set's output codec. It returns the size and MD5 of what was written
+// and the source objects that were quarantined instead.
 func MergeObjects(
 	ctx context.Context,
 	client s3iface.S3API,
 	destination models.DestinationObject,
 	sourceObjects []models.SourceObject,
-) (int64, error) {
+) (MergeOutput, error) {
 	uploader := s3manager.NewUploaderWithClient(client)
 
+	sum := newPartHash(uploader.PartSize)
 	r, w := io.Pipe()
+	m := newMerge(ctx, client, destination.Parent, io.MultiWriter(w, sum))
 	nCh := make(chan int64)
 	go func() {
 		defer close(nCh)
-		var n int64
-		for _, source := range sourceObjects {
-			output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(source.Parent.Bucket),
-				Key:    source.Key,
-			})
-			if err != nil {
-				w.CloseWithError(err)
-				return
-			}
+		err := m.write(sourceObjects)
+		if err == nil {
+			err = m.cw.Close()
+		}
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		w.Close()
+		nCh <- m.cw.n
+	}()
 
-			written, err := io.Copy(w, output.Body)
-			output.Body.Close()
+	_, err := uploader.UploadWithContext(ctx, uploadInput(destination, r))
+	if err != nil {
+		r.CloseWithError(err)
+		return MergeOutput{Size: <-nCh}, err
+	}
 
-			if err != nil {
-				w.CloseWithError(err)
-				return
-			}
+	merged := MergeOutput{
+		Size:        <-nCh,
+		MD5:         sum.Sum(nil),
+		PartMD5s:    sum.Parts(),
+		Quarantined: m.quarantined,
+	}
+	if m.indexed {
+		if err = writeIndex(ctx, uploader, destination, m.entries); err != nil {
+			return merged, err
+		}
+	}
+	return merged, nil
+}
+
+// uploadInput sets the content encoding and type of a destination object
+func uploadInput(
+	destination models.DestinationObject,
+	body io.Reader,
+) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(destination.Parent.Bucket),
+		Key:    destination.Key,
+		Body:   body,
+	}
+	if outputCodec := destination.Parent.OutputCodec(); outputCodec != codec.None {
+		key := aws.StringValue(destination.Key)
+		input.ContentEncoding = aws.String(codec.ContentEncoding(outputCodec))
+		if t := mime.TypeByExtension(path.Ext(strings.TrimSuffix(key, path.Ext(key)))); t != "" {
+			input.ContentType = aws.String(t)
+		}
+	}
+	if t := destination.Parent.ArchiveContentType(); t != "" {
+		input.ContentType = aws.String(t)
+	}
+
+	return input
+}
+
+// merge writes the source objects of a destination object and keeps track of
+// the ones quarantined and the index entries of the ones written
+type merge struct {
+	ctx    context.Context
+	client s3iface.S3API
+	set    models.ObjectSet
+	cw     *codecWriter
+
+	header  *csvHeader
+	json    *jsonMerger
+	indexed bool
+	// start is where the bytes of the current source object start
+	start int64
+
+	entries     []lineage.Entry
+	quarantined []SourceError
+}
+
+func newMerge(
+	ctx context.Context,
+	client s3iface.S3API,
+	set models.ObjectSet,
+	w io.Writer,
+) *merge {
+	m := &merge{
+		ctx:    ctx,
+		client: client,
+		set:    set,
+		cw: &codecWriter{
+			w:        w,
+			codec:    set.OutputCodec(),
+			decode:   set.DecodesSources(),
+			stripBOM: set.StripBOM,
+
+			transforms: set.Transforms,
+		},
+		indexed: set.Index != lineage.None,
+	}
+	if set.DelimiterMode == models.DelimiterIfMissing {
+		m.cw.keep = len(set.Delimiter)
+	}
+	if set.CSVHeader {
+		m.header = &csvHeader{mismatch: set.HeaderMismatch}
+	}
+	if set.JSONMode != "" {
+		m.json = &jsonMerger{mode: set.JSONMode}
+	}
 
-			dWritten, err := w.Write(destination.Parent.Delimiter)
-			if err != nil {
-				w.CloseWithError(err)
-				return
+	return m
+}
+
+// write writes the source objects as archive entries, framed records, or
+// concatenated
+func (m *merge) write(sources []models.SourceObject) error {
+	switch {
+	case m.set.Archive != "":
+		return writeArchive(m.ctx, m.client, m.cw, m.set, sources)
+	case m.set.Framing != framing.None:
+		return writeRecords(m.ctx, m.client, m.cw, m.set, sources)
+	}
+
+	return m.concatenate(sources)
+}
+
+// concatenate writes the header, the source objects delimited as per the
+// object set's delimiter placement, and the footer. JSON source objects are
+// written by the JSON merger without delimiters.
+func (m *merge) concatenate(sources []models.SourceObject) error {
+	if _, err := m.cw.Write(m.set.Header); err != nil {
+		return err
+	}
+	m.cw.tail = m.cw.tail[:0]
+	if m.json != nil {
+		if err := m.json.open(m.cw); err != nil {
+			return err
+		}
+	}
+
+	between := m.set.DelimiterPlacement == models.DelimiterBetween
+	for i, source := range sources {
+		quarantined, err := m.copySource(i, source)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case quarantined || m.json != nil:
+		case between:
+			m.cw.pending = m.delimit
+		default:
+			if err = m.delimit(); err != nil {
+				return err
 			}
+		}
+	}
+	m.cw.pending = nil
 
-			n += written + int64(dWritten)
+	if m.json != nil {
+		if err := m.json.close(m.cw); err != nil {
+			return err
 		}
-		w.Close()
-		nCh <- n
-	}()
+	}
+	_, err := m.cw.Write(m.set.Footer)
+	return err
+}
+
+// copySource gets a source object and copies it as CSV, JSON, or as it is,
+// adding its index entry. It reports whether the source object was
+// quarantined.
+func (m *merge) copySource(i int, source models.SourceObject) (bool, error) {
+	output, err := m.client.GetObjectWithContext(m.ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(source.Parent.Bucket),
+		Key:       source.Key,
+		VersionId: source.VersionID,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer output.Body.Close()
+
+	if m.indexed {
+		if m.start, err = m.cw.boundary(); err != nil {
+			return false, err
+		}
+	}
+
+	var reason error
+	switch {
+	case m.header != nil:
+		reason, err = m.header.copySource(m.cw, output.Body, source)
+	case m.json != nil:
+		reason, err = m.json.copySource(m.cw, output.Body, source)
+	default:
+		err = m.cw.copySource(output.Body, aws.StringValue(source.Key))
+	}
+	if err != nil {
+		return false, err
+	}
+	if reason != nil {
+		m.quarantined = append(m.quarantined, SourceError{Index: i, Err: reason})
+		return true, nil
+	}
+
+	if m.indexed {
+		etag := source.ETag
+		if etag == nil {
+			etag = output.ETag
+		}
+		return false, m.addEntry(aws.StringValue(source.Key), aws.StringValue(etag))
+	}
+	return false, nil
+}
+
+// addEntry ends the current source object's bytes and adds its index entry
+func (m *merge) addEntry(key string, etag string) error {
+	end, err := m.cw.boundary()
+	if err != nil {
+		return err
+	}
+
+	m.entries = append(m.entries, lineage.Entry{
+		Key:    key,
+		ETag:   etag,
+		Offset: m.start,
+		Length: end - m.start,
+	})
+	return nil
+}
+
+// delimit writes the delimiter, in a gzip member of its own when the object
+// set writes gzip members, unless it is only written when missing and the
+// bytes written already end with it
+func (m *merge) delimit() (err error) {
+	if m.set.DelimiterMode == models.DelimiterIfMissing &&
+		!m.cw.unterminated(m.set.Delimiter) {
+		return nil
+	}
+
+	delimiter := m.set.EncodedDelimiter()
+	if m.set.GzipMembers {
+		err = m.cw.writeRaw(delimiter)
+	} else {
+		_, err = m.cw.Write(delimiter)
+	}
+	if err == nil && m.indexed {
+		m.start, err = m.cw.boundary()
+	}
+	return err
+}
+
+// writeIndex writes the sidecar index of a destination object next to it
+func writeIndex(
+	ctx context.Context,
+	uploader *s3manager.Uploader,
+	destination models.DestinationObject,
+	entries []lineage.Entry,
+) error {
+	var buf bytes.Buffer
+	if err := lineage.Encode(&buf, destination.Parent.Index, entries); err != nil {
+		return err
+	}
 
 	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-		Bucket: aws.String(destination.Parent.Bucket),
-		Key:    destination.Key,
-		Body:   r,
+		Bucket:      aws.String(destination.Parent.Bucket),
+		Key:         aws.String(destination.Parent.IndexKey(aws.StringValue(destination.Key))),
+		Body:        &buf,
+		ContentType: aws.String(lineage.ContentType(destination.Parent.Index)),
 	})
+	return err
+}
+
+// csvHeader writes the header row of the first CSV source object and strips
+// it from every following one
+type csvHeader struct {
+	mismatch string
+	header   []byte
+}
+
+// copySource decompresses a CSV source object and copies it without its
+// header row unless it is the first one. The rows following the header row
+// are copied through the transforms. A header row that does not match fails
+// the copy, or is the reason the source object is quarantined when the object
+// set quarantines mismatched headers.
+func (h *csvHeader) copySource(
+	cw *codecWriter,
+	body io.Reader,
+	source models.SourceObject,
+) (error, error) {
+	dr, err := decompress(body, cw.stripBOM)
 	if err != nil {
-		r.CloseWithError(err)
-		return <-nCh, err
+		return nil, err
+	}
+	defer dr.Close()
+
+	lr := bufio.NewReader(dr)
+	line, err := lr.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case len(line) == 0:
+		return nil, nil
+	case h.header == nil:
+		h.header = line
+		if _, err = cw.Write(line); err != nil {
+			return nil, err
+		}
+	case !bytes.Equal(trimEOL(line), trimEOL(h.header)):
+		if h.mismatch == models.HeaderMismatchQuarantine {
+			return ErrHeaderMismatch, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrHeaderMismatch, aws.StringValue(source.Key))
+	}
+
+	return nil, copyLines(cw, lr, cw.transforms, aws.StringValue(source.Key))
+}
+
+func trimEOL(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}
+
+// codecWriter writes a destination object compressed with a codec. Bytes
+// written to it are compressed into the current gzip member or frame, which is
+// ended whenever already compressed bytes are copied as they are. It counts
+// the bytes written to the destination object and keeps the last keep bytes
+// written to it before they are compressed.
+type codecWriter struct {
+	w     io.Writer
+	codec string
+	enc   io.WriteCloser
+	n     int64
+
+	decode   bool
+	stripBOM bool
+	keep     int
+	tail     []byte
+	pending  func() error
+
+	transforms models.Transforms
+}
+
+func (c *codecWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+	}
+	c.record(p)
+
+	if c.codec == codec.None {
+		n, err := c.w.Write(p)
+		c.n += int64(n)
+		return n, err
+	}
+
+	if c.enc == nil && len(p) > 0 {
+		enc, err := codec.NewWriter(c.codec, countWriter{c})
+		if err != nil {
+			return 0, err
+		}
+		c.enc = enc
+	}
+	if c.enc == nil {
+		return 0, nil
+	}
+
+	return c.enc.Write(p)
+}
+
+// flush writes the delimiter pending before the next bytes of a source object
+func (c *codecWriter) flush() error {
+	if c.pending == nil {
+		return nil
+	}
+
+	pending := c.pending
+	c.pending = nil
+	return pending()
+}
+
+// record keeps the last bytes of p, after those already kept
+func (c *codecWriter) record(p []byte) {
+	if c.keep == 0 || len(p) == 0 {
+		return
 	}
+	if len(p) >= c.keep {
+		c.tail = append(c.tail[:0], p[len(p)-c.keep:]...)
+		return
+	}
+
+	c.tail = append(c.tail, p...)
+	if len(c.tail) > c.keep {
+		c.tail = append(c.tail[:0], c.tail[len(c.tail)-c.keep:]...)
+	}
+}
+
+// unterminated reports whether bytes were written since the last delimiter
+// and they do not end with the delimiter
+func (c *codecWriter) unterminated(delimiter []byte) bool {
+	return len(c.tail) > 0 && !bytes.HasSuffix(c.tail, delimiter)
+}
+
+// boundary ends the current member or frame and returns the number of bytes
+// written to the destination object, so that the bytes written next can be
+// decompressed on their own
+func (c *codecWriter) boundary() (int64, error) {
+	err := c.Close()
+	return c.n, err
+}
+
+// writeRaw ends the current member or frame and writes p as it is, the raw
+// bytes end anything written before them
+func (c *codecWriter) writeRaw(p []byte) error {
+	if err := c.Close(); err != nil {
+		return err
+	}
+	c.tail = c.tail[:0]
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return err
+}
+
+// copySource copies the body of the source object with the passed key. Unless
+// every source object is decoded, a body compressed with the output codec is
+// copied as it is and any other compressed body is decompressed. Decoded
+// bodies are copied through the transforms.
+func (c *codecWriter) copySource(body io.Reader, key string) error {
+	if c.decode {
+		dr, err := decompress(body, c.stripBOM)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+
+		return copyLines(c, dr, c.transforms, key)
+	}
+	if c.codec == codec.None {
+		_, err := io.Copy(c, body)
+		return err
+	}
+
+	sourceCodec, br, err := codec.Peek(body)
+	if err != nil {
+		return err
+	}
+
+	if sourceCodec == c.codec {
+		if err = c.flush(); err != nil {
+			return err
+		}
+		if err = c.Close(); err != nil {
+			return err
+		}
+		n, err := io.Copy(c.w, br)
+		c.n += n
+		return err
+	}
+
+	dr, err := codec.NewReader(sourceCodec, br)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	_, err = io.Copy(c, dr)
+	return err
+}
+
+// Close ends the current member or frame
+func (c *codecWriter) Close() error {
+	if c.enc == nil {
+		return nil
+	}
+
+	err := c.enc.Close()
+	c.enc = nil
+	return err
+}
+
+// countWriter counts the compressed bytes written to a codecWriter's
+// destination
+type countWriter struct {
+	c *codecWriter
+}
 
-	return <-nCh, nil
+func (w countWriter) Write(p []byte) (int, error) {
+	n, err := w.c.w.Write(p)
+	w.c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,272 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"s3fc/codec"
+	"s3fc/models"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// testServer serves objects, keyed by "/bucket/key", as a path style S3
+// endpoint and stores the objects put to it
+func testServer(objects map[string][]byte) (*httptest.Server, s3iface.S3API) {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPut {
+			objects[r.URL.Path], _ = ioutil.ReadAll(r.Body)
+			return
+		}
+
+		b, ok := objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sum := md5.Sum(b)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	}))
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return srv, s3.New(sess)
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(codec.Gzip, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// mergeSources merges the sources of an object set into "/b/out" and returns
+// what was written
+func mergeSources(
+	t *testing.T,
+	set *models.ObjectSet,
+	sources [][]byte,
+) (string, MergeOutput, error) {
+	objects := make(map[string][]byte)
+	srv, client := testServer(objects)
+	defer srv.Close()
+
+	dest := models.NewDestinationObject(*set)
+	dest.Key = aws.String("out")
+	var sourceObjects []models.SourceObject
+	for i, b := range sources {
+		key := fmt.Sprintf("p/%d", i)
+		objects["/b/"+key] = b
+		s := models.NewSourceObject(*set)
+		s.Key = aws.String(key)
+		sourceObjects = append(sourceObjects, *s)
+	}
+
+	merged, err := MergeObjects(context.Background(), client, *dest, sourceObjects)
+	return string(objects["/b/out"]), merged, err
+}
+
+// quarantinedIndexes the indexes of the quarantined source objects
+func quarantinedIndexes(merged MergeOutput) []int {
+	indexes := []int{}
+	for _, q := range merged.Quarantined {
+		indexes = append(indexes, q.Index)
+	}
+	return indexes
+}
+
+func TestMergeObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     models.ObjectSet
+		sources []string
+		want    string
+	}{
+		{
+			name:    "delimiter",
+			set:     models.ObjectSet{Delimiter: []byte("\n")},
+			sources: []string{"a", "b\n", ""},
+			want:    "a\nb\n\n\n",
+		},
+		{
+			name: "delimiter if missing",
+			set: models.ObjectSet{
+				Delimiter:     []byte("\n"),
+				DelimiterMode: models.DelimiterIfMissing,
+			},
+			sources: []string{"a", "b\n", ""},
+			want:    "a\nb\n",
+		},
+		{
+			name: "delimiter between with header and footer",
+			set: models.ObjectSet{
+				Delimiter:          []byte(","),
+				DelimiterPlacement: models.DelimiterBetween,
+				Header:             []byte("["),
+				Footer:             []byte("]"),
+			},
+			sources: []string{"1", "2", "3"},
+			want:    "[1,2,3]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := tt.set
+			set.Bucket = "b"
+			var sources [][]byte
+			for _, s := range tt.sources {
+				sources = append(sources, []byte(s))
+			}
+
+			got, merged, err := mergeSources(t, &set, sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("MergeObjects() wrote %q, want %q", got, tt.want)
+			}
+			if merged.Size != int64(len(got)) {
+				t.Errorf("MergeObjects() size = %d, want %d", merged.Size, len(got))
+			}
+			if sum := md5.Sum([]byte(got)); !bytes.Equal(merged.MD5, sum[:]) {
+				t.Errorf("MergeObjects() MD5 = %x, want %x", merged.MD5, sum)
+			}
+		})
+	}
+}
+
+func TestMergeObjectsCSV(t *testing.T) {
+	sources := [][]byte{
+		[]byte(""),
+		[]byte("a,b\r\n1,2\r\n"),
+		gzipped(t, "a,b\n3,4\n"),
+		[]byte("a,c\n5,6\n"),
+		[]byte("a,b\n"),
+	}
+
+	tests := []struct {
+		name        string
+		mismatch    string
+		want        string
+		quarantined []int
+		wantErr     bool
+	}{
+		{name: "default rejects", wantErr: true},
+		{name: "reject", mismatch: models.HeaderMismatchReject, wantErr: true},
+		{
+			name:        "quarantine",
+			mismatch:    models.HeaderMismatchQuarantine,
+			want:        "a,b\r\n1,2\r\n3,4\n",
+			quarantined: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := models.NewObjectSet("b", "p/")
+			set.CSVHeader = true
+			set.HeaderMismatch = tt.mismatch
+
+			got, merged, err := mergeSources(t, set, sources)
+			// the upload wraps the error of the merge
+			if (err != nil) != tt.wantErr ||
+				err != nil && !strings.Contains(err.Error(), ErrHeaderMismatch.Error()) {
+				t.Fatalf("MergeObjects() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("MergeObjects() wrote %q, want %q", got, tt.want)
+			}
+			if q := quarantinedIndexes(merged); !reflect.DeepEqual(q, tt.quarantined) {
+				t.Errorf("MergeObjects() quarantined %v, want %v", q, tt.quarantined)
+			}
+		})
+	}
+}
+
+func TestMergeObjectsJSON(t *testing.T) {
+	sources := [][]byte{
+		[]byte(""),
+		[]byte(`[1, {"a": [1, 2]}]`),
+		gzipped(t, "{\"b\":  2}\n{\"c\":3}\n"),
+		[]byte("{bad"),
+		[]byte("[]"),
+		[]byte("[1,2"),
+		[]byte(" 5 \n"),
+	}
+
+	tests := []struct {
+		mode        string
+		want        string
+		quarantined []int
+	}{
+		{
+			mode:        models.JSONArray,
+			want:        "[\n1,\n{\"a\":[1,2]},\n{\"b\":2},\n{\"c\":3},\n5\n]\n",
+			quarantined: []int{3, 5},
+		},
+		{
+			mode:        models.JSONLines,
+			want:        "[1,{\"a\":[1,2]}]\n{\"b\":2}\n{\"c\":3}\n[]\n5\n",
+			quarantined: []int{3, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			set := models.NewObjectSet("b", "p/")
+			set.JSONMode = tt.mode
+
+			got, merged, err := mergeSources(t, set, sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("MergeObjects() wrote %q, want %q", got, tt.want)
+			}
+			if tt.mode == models.JSONArray && !json.Valid([]byte(got)) {
+				t.Errorf("MergeObjects() wrote invalid JSON %q", got)
+			}
+			if q := quarantinedIndexes(merged); !reflect.DeepEqual(q, tt.quarantined) {
+				t.Errorf("MergeObjects() quarantined %v, want %v", q, tt.quarantined)
+			}
+		})
+	}
+}
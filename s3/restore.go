@@ -0,0 +1,108 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"s3fc/codec"
+	"s3fc/lineage"
+	"s3fc/models"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+var (
+	// ErrSizeMismatch tells a caller that the bytes of a source object read
+	// from its destination object do not have the size it was stored with
+	ErrSizeMismatch = errors.New("restored size does not match the source object")
+	// ErrETagMismatch tells a caller that the bytes of a source object read
+	// from its destination object do not match its ETag
+	ErrETagMismatch = errors.New("restored ETag does not match the source object")
+)
+
+// ReadIndex reads the sidecar index of a destination object
+func ReadIndex(
+	ctx context.Context,
+	client s3iface.S3API,
+	destination models.DestinationObject,
+) ([]lineage.Entry, error) {
+	output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(destination.Parent.Bucket),
+		Key:    aws.String(destination.Parent.IndexKey(aws.StringValue(destination.Key))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	return lineage.Decode(output.Body, destination.Parent.Index)
+}
+
+// RestoreSource reads the bytes of a source object from its destination
+// object with a ranged GET and writes them to bucket and key. The bytes are
+// written as they are stored in the destination object when their size matches
+// the source object's, otherwise the member or frame they are compressed in is
+// decompressed. Single part ETags are verified as the MD5 of the bytes.
+func RestoreSource(
+	ctx context.Context,
+	client s3iface.S3API,
+	destination models.DestinationObject,
+	source models.SourceObject,
+	entry lineage.Entry,
+	bucket string,
+	key string,
+) error {
+	var data []byte
+	if entry.Length > 0 {
+		output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(destination.Parent.Bucket),
+			Key:    destination.Key,
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", entry.Offset, entry.Offset+entry.Length-1)),
+		})
+		if err != nil {
+			return err
+		}
+		data, err = ioutil.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	size := aws.Int64Value(source.Size)
+	if int64(len(data)) != size && destination.Parent.OutputCodec() != codec.None {
+		dr, err := codec.Decompress(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if data, err = ioutil.ReadAll(dr); err != nil {
+			return err
+		}
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("%w: %s", ErrSizeMismatch, aws.StringValue(source.Key))
+	}
+
+	etag := strings.Trim(aws.StringValue(source.ETag), "\"")
+	if etag != "" && !strings.Contains(etag, "-") {
+		sum := md5.Sum(data)
+		if hex.EncodeToString(sum[:]) != etag {
+			return fmt.Errorf("%w: %s", ErrETagMismatch, aws.StringValue(source.Key))
+		}
+	}
+
+	_, err := s3manager.NewUploaderWithClient(client).UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
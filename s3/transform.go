@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"s3fc/models"
+)
+
+// lineWriter applies an object set's transforms to every line of a source
+// object written to it. A line is only passed on once it is complete, Close
+// passes on a last line that does not end with a new line.
+type lineWriter struct {
+	w          io.Writer
+	transforms models.Transforms
+	key        string
+	buf        []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.buf = append(l.buf, p...)
+			break
+		}
+
+		line := p[:i+1]
+		if len(l.buf) > 0 {
+			l.buf = append(l.buf, line...)
+			line = l.buf
+		}
+		if err := l.writeLine(line); err != nil {
+			return 0, err
+		}
+		l.buf = l.buf[:0]
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+func (l *lineWriter) writeLine(line []byte) error {
+	output, ok := l.transforms.Apply(line, l.key)
+	if !ok {
+		return nil
+	}
+
+	_, err := l.w.Write(output)
+	return err
+}
+
+// Close writes the last line if it does not end with a new line
+func (l *lineWriter) Close() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+
+	err := l.writeLine(l.buf)
+	l.buf = l.buf[:0]
+	return err
+}
+
+// copyLines copies r to w, through the transforms when there are any
+func copyLines(w io.Writer, r io.Reader, transforms models.Transforms, key string) error {
+	if len(transforms) == 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	lw := &lineWriter{w: w, transforms: transforms, key: key}
+	if _, err := io.Copy(lw, r); err != nil {
+		return err
+	}
+	return lw.Close()
+}
@@ -0,0 +1,177 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"s3fc/models"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// deleteBatchSize the maximum number of keys of a DeleteObjects request
+const deleteBatchSize = 1000
+
+var (
+	// ErrVerification tells a caller that a stored destination object does not
+	// match what was written to it
+	ErrVerification = errors.New("destination object does not match what was written")
+)
+
+// VerifyDestination checks that a stored destination object has the size of
+// what MergeObjects wrote and an ETag matching their checksum, the MD5 of
+// their bytes or, for objects uploaded in parts, the MD5 of the MD5s of their
+// parts. The ETag of objects encrypted with KMS is not an MD5, so it reports
+// false when the destination object can not be verified.
+func VerifyDestination(
+	ctx context.Context,
+	client s3iface.S3API,
+	destination models.DestinationObject,
+	merged MergeOutput,
+) (bool, error) {
+	head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(destination.Parent.Bucket),
+		Key:    destination.Key,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if size := aws.Int64Value(head.ContentLength); size != merged.Size {
+		return false, fmt.Errorf("%w: size %d, expected %d", ErrVerification, size, merged.Size)
+	}
+
+	if aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms {
+		return false, nil
+	}
+
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	expected := hex.EncodeToString(merged.MD5)
+	if strings.Contains(etag, "-") {
+		if len(merged.PartMD5s) == 0 {
+			return false, nil
+		}
+		expected = multipartETag(merged.PartMD5s)
+	}
+	if etag != expected {
+		return false, fmt.Errorf("%w: ETag %s, expected %s", ErrVerification, etag, expected)
+	}
+
+	return true, nil
+}
+
+// multipartETag the ETag S3 gives an object uploaded in parts
+func multipartETag(parts [][]byte) string {
+	sum := md5.New()
+	for _, p := range parts {
+		sum.Write(p)
+	}
+
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum.Sum(nil)), len(parts))
+}
+
+// partHash computes the MD5 of what is written to it, and of every part of
+// partSize bytes as the uploader splits it
+type partHash struct {
+	hash.Hash
+	partSize int64
+	part     hash.Hash
+	n        int64
+	parts    [][]byte
+}
+
+func newPartHash(partSize int64) *partHash {
+	return &partHash{Hash: md5.New(), partSize: partSize, part: md5.New()}
+}
+
+func (h *partHash) Write(p []byte) (int, error) {
+	h.Hash.Write(p)
+
+	n := len(p)
+	for len(p) > 0 {
+		c := h.partSize - h.n
+		if int64(len(p)) < c {
+			c = int64(len(p))
+		}
+		h.part.Write(p[:c])
+		h.n += c
+		p = p[c:]
+
+		if h.n == h.partSize {
+			h.parts = append(h.parts, h.part.Sum(nil))
+			h.part.Reset()
+			h.n = 0
+		}
+	}
+
+	return n, nil
+}
+
+// Parts the MD5s of the parts written so far, the last one included
+func (h *partHash) Parts() [][]byte {
+	if h.n == 0 {
+		return h.parts
+	}
+
+	return append(h.parts[:len(h.parts):len(h.parts)], h.part.Sum(nil))
+}
+
+// DeleteSourceObjects deletes source objects, the versions they were merged
+// from in versioned buckets, with batched DeleteObjects requests. It returns
+// the indexes of the source objects that were deleted and the errors of those
+// that were not.
+func DeleteSourceObjects(
+	ctx context.Context,
+	client s3iface.S3API,
+	bucket string,
+	sourceObjects []models.SourceObject,
+) ([]int, []SourceError, error) {
+	var deleted []int
+	var failed []SourceError
+
+	for start := 0; start < len(sourceObjects); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(sourceObjects) {
+			end = len(sourceObjects)
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, source := range sourceObjects[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{
+				Key:       source.Key,
+				VersionId: source.VersionID,
+			})
+		}
+
+		output, err := client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, failed, err
+		}
+
+		errs := make(map[string]error)
+		for _, e := range output.Errors {
+			errs[aws.StringValue(e.Key)+"\x00"+aws.StringValue(e.VersionId)] = fmt.Errorf(
+				"%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message),
+			)
+		}
+		for i := start; i < end; i++ {
+			source := sourceObjects[i]
+			if err, ok := errs[aws.StringValue(source.Key)+"\x00"+aws.StringValue(source.VersionID)]; ok {
+				failed = append(failed, SourceError{Index: i, Err: err})
+				continue
+			}
+			deleted = append(deleted, i)
+		}
+	}
+
+	return deleted, failed, nil
+}
@@ -0,0 +1,133 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"s3fc/models"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// headServer answers HEAD requests with a stored object's size, ETag and
+// server side encryption
+func headServer(size int, etag string, sse string) (*httptest.Server, s3iface.S3API) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+etag+`"`)
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		if sse != "" {
+			w.Header().Set("x-amz-server-side-encryption", sse)
+		}
+	}))
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return srv, s3.New(sess)
+}
+
+// hashed what MergeObjects returns for written bytes uploaded in parts of
+// partSize bytes
+func hashed(written []byte, partSize int64) MergeOutput {
+	h := newPartHash(partSize)
+	h.Write(written[:3])
+	h.Write(written[3:])
+	return MergeOutput{Size: int64(len(written)), MD5: h.Sum(nil), PartMD5s: h.Parts()}
+}
+
+func TestVerifyDestination(t *testing.T) {
+	written := []byte("abcdefgh")
+	sum := md5.Sum(written)
+	etag := hex.EncodeToString(sum[:])
+
+	// the ETag of written uploaded in parts of 3 bytes
+	var parts []byte
+	for _, p := range []string{"abc", "def", "gh"} {
+		s := md5.Sum([]byte(p))
+		parts = append(parts, s[:]...)
+	}
+	s := md5.Sum(parts)
+	multipart := hex.EncodeToString(s[:]) + "-3"
+
+	tests := []struct {
+		name     string
+		size     int
+		etag     string
+		sse      string
+		merged   MergeOutput
+		verified bool
+		wantErr  error
+	}{
+		{
+			name:     "matches",
+			size:     len(written),
+			etag:     etag,
+			merged:   hashed(written, 5<<20),
+			verified: true,
+		},
+		{
+			name:    "size",
+			size:    2,
+			etag:    etag,
+			merged:  hashed(written, 5<<20),
+			wantErr: ErrVerification,
+		},
+		{
+			name:    "checksum",
+			size:    len(written),
+			etag:    hex.EncodeToString(make([]byte, 16)),
+			merged:  hashed(written, 5<<20),
+			wantErr: ErrVerification,
+		},
+		{
+			name:     "multipart",
+			size:     len(written),
+			etag:     multipart,
+			merged:   hashed(written, 3),
+			verified: true,
+		},
+		{
+			name:    "multipart with other parts",
+			size:    len(written),
+			etag:    multipart,
+			merged:  hashed(written, 4),
+			wantErr: ErrVerification,
+		},
+		{
+			name:   "kms",
+			size:   len(written),
+			etag:   "0123",
+			sse:    s3.ServerSideEncryptionAwsKms,
+			merged: hashed(written, 5<<20),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := headServer(tt.size, tt.etag, tt.sse)
+			defer srv.Close()
+
+			dest := models.NewDestinationObject(*models.NewObjectSet("b", "p/"))
+			dest.Key = aws.String("out")
+			verified, err := VerifyDestination(context.Background(), client, *dest, tt.merged)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyDestination() error = %v, want %v", err, tt.wantErr)
+			}
+			if verified != tt.verified {
+				t.Errorf("VerifyDestination() = %v, want %v", verified, tt.verified)
+			}
+		})
+	}
+}